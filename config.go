@@ -3,13 +3,24 @@
 package log4go
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type xmlProperty struct {
@@ -23,13 +34,202 @@ type xmlFilter struct {
 	Level    string        `xml:"level"`
 	Type     string        `xml:"type"`
 	Property []xmlProperty `xml:"property"`
+	Filter   []xmlFilter   `xml:"filter"`
+}
+
+type xmlInclude struct {
+	File string `xml:"file,attr"`
 }
 
 type xmlLoggerConfig struct {
-	Filter []xmlFilter `xml:"filter"`
+	Include  []xmlInclude  `xml:"include"`
+	Format   string        `xml:"format"`
+	Property []xmlProperty `xml:"property"`
+	Filter   []xmlFilter   `xml:"filter"`
+}
+
+type jsonFilter struct {
+	Enabled    interface{}            `json:"enabled"`
+	Tag        string                 `json:"tag"`
+	Level      string                 `json:"level"`
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Filters    []jsonFilter           `json:"filters"`
+}
+
+type jsonLoggerConfig struct {
+	Format  string       `json:"format"`
+	Filters []jsonFilter `json:"filters"`
+}
+
+// filterConfig is the format-neutral description of a single filter.  Each
+// configuration format is converted into a list of these before the filters
+// are validated and built, so that all formats behave identically.  A filter
+// may nest further filters, whose writers all receive its records; only their
+// type and properties are used.
+type filterConfig struct {
+	Enabled    string
+	Tag        string
+	Level      string
+	Type       string
+	Properties map[string]string
+	Children   []filterConfig
+}
+
+func trimProp(value string) string {
+	return strings.Trim(value, " \r\n")
+}
+
+func (xf xmlFilter) filterConfig() filterConfig {
+	props := make(map[string]string, len(xf.Property))
+	for _, prop := range xf.Property {
+		props[prop.Name] = trimProp(prop.Value)
+	}
+	children := make([]filterConfig, 0, len(xf.Filter))
+	for _, child := range xf.Filter {
+		children = append(children, child.filterConfig())
+	}
+	return filterConfig{
+		Enabled:    xf.Enabled,
+		Tag:        xf.Tag,
+		Level:      xf.Level,
+		Type:       xf.Type,
+		Properties: props,
+		Children:   children,
+	}
+}
+
+// configValueString renders a scalar JSON or YAML value the way it would be
+// written in the equivalent XML configuration.
+func configValueString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func (jf jsonFilter) filterConfig() filterConfig {
+	props := make(map[string]string, len(jf.Properties))
+	for name, value := range jf.Properties {
+		props[name] = trimProp(configValueString(value))
+	}
+	children := make([]filterConfig, 0, len(jf.Filters))
+	for _, child := range jf.Filters {
+		children = append(children, child.filterConfig())
+	}
+	return filterConfig{
+		Enabled:    configValueString(jf.Enabled),
+		Tag:        jf.Tag,
+		Level:      jf.Level,
+		Type:       jf.Type,
+		Properties: props,
+		Children:   children,
+	}
+}
+
+// The filter types which take the configuration's default format
+var defaultFormatTypes = map[string]bool{"console": true, "file": true}
+
+// applyDefaultFormat gives the console and file filters, including nested
+// ones, which have no format property of their own the configuration's
+// default format, if it has one.
+func applyDefaultFormat(filters []filterConfig, format string) {
+	if len(format) == 0 {
+		return
+	}
+	for _, fc := range filters {
+		if _, ok := fc.Properties["format"]; !ok && defaultFormatTypes[fc.Type] {
+			fc.Properties["format"] = format
+		}
+		applyDefaultFormat(fc.Children, format)
+	}
+}
+
+// defaultFormat returns the default format of an XML configuration, given
+// by a top-level <format> or <property name="format">
+func (xc *xmlLoggerConfig) defaultFormat(filename string) string {
+	format := trimProp(xc.Format)
+	for _, prop := range xc.Property {
+		switch prop.Name {
+		case "format":
+			if len(format) == 0 {
+				format = trimProp(prop.Value)
+			}
+		default:
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for logging in %s\n", prop.Name, filename)
+		}
+	}
+	return format
+}
+
+// expandEnv replaces ${VAR} and $VAR references in a property value with the
+// contents of the named environment variables.  Unset variables expand to the
+// empty string and are reported as a warning.
+func expandEnv(filename, filtType, value string) string {
+	return os.Expand(value, func(name string) string {
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			configWarning("LoadConfiguration: Warning: Environment variable \"%s\" for %s filter is not set in %s\n", name, filtType, filename)
+		}
+		return val
+	})
+}
+
+// parseBool parses a boolean property, accepting true/false, 1/0, yes/no and
+// on/off in any case.  Anything else is warned about and treated as false.
+func parseBool(filename, filtType, name, value string) bool {
+	b, ok := boolValue(value)
+	if !ok {
+		configWarning("LoadConfiguration: Warning: Property \"%s\" for %s filter in %s is not a boolean: %s\n", name, filtType, filename, value)
+	}
+	return b
+}
+
+// filterEnabled parses the enabled attribute of a filter like parseBool.
+// Nested filters may leave it out, and are then enabled.
+func filterEnabled(filename, value string) bool {
+	if len(value) == 0 {
+		return true
+	}
+	b, ok := boolValue(value)
+	if !ok {
+		configWarning("LoadConfiguration: Warning: Attribute %s for filter in %s is not a boolean: %s\n", "enabled", filename, value)
+	}
+	return b
+}
+
+func boolValue(value string) (b, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "on":
+		return true, true
+	case "false", "0", "no", "off":
+		return false, true
+	}
+	return false, false
 }
 
-// Load XML configuration; see examples/example.xml for documentation
+// sortedPropNames returns the property names in a stable order so that
+// warnings are reported deterministically.
+func sortedPropNames(props map[string]string) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load XML configuration; see examples/example.xml for documentation.  The
+// logger's filters are replaced; its other settings, such as hooks and
+// redactors, are kept.
 func (log Logger) LoadConfiguration(filename string) error {
 
 	// Open the configuration file
@@ -43,97 +243,618 @@ func (log Logger) LoadConfiguration(filename string) error {
 	return log.LoadConfigurationFromReader(fd, filename)
 }
 
-// Load XML configuration from a reader
+// Load XML configuration from a reader.  Warnings, such as for unknown
+// properties, are written to standard error.
 func (log Logger) LoadConfigurationFromReader(r io.Reader, filename string) error {
-	log.Close()
+	return log.loadXMLConfiguration(r, filename, nil, nil)
+}
+
+// Load XML configuration from a reader like LoadConfigurationFromReader, but
+// return any warnings, such as for unknown properties, instead of writing them
+// to standard error.  In strict mode, see SetStrictConfig, warnings are
+// returned as errors instead.
+func (log Logger) LoadConfigurationFromReaderWarnings(r io.Reader, filename string) (warnings []string, err error) {
+	err = log.loadXMLConfiguration(r, filename, nil, func(warning string) {
+		warnings = append(warnings, warning)
+	})
+	return warnings, err
+}
+
+var (
+	// Serializes loading configurations, so that the warnings of each load
+	// are passed to its configWarn
+	configLoadMu sync.Mutex
+	configWarn   func(warning string)
+
+	// Non-zero if configuration warnings are errors, see SetStrictConfig
+	strictConfig int32
+)
+
+// SetStrictConfig sets whether configurations are loaded in strict mode, in
+// which anything that would be a warning, such as an unknown property, is an
+// error instead, e.g. to fail a CI build on a mistyped configuration.  A
+// configuration which fails this way loads no filters, and every problem
+// found is returned together as ConfigErrors.  This applies to every
+// configuration loaded, and to ValidateConfiguration.
+func SetStrictConfig(strict bool) {
+	var v int32
+	if strict {
+		v = 1
+	}
+	atomic.StoreInt32(&strictConfig, v)
+}
+
+// withConfigWarnings runs load, which loads a configuration into log, passing
+// any configuration warnings to warn, or writing them to standard error if
+// warn is nil.  In strict mode the warnings are returned as errors instead,
+// and log is closed.
+func withConfigWarnings(log Logger, warn func(warning string), load func() error) error {
+	configLoadMu.Lock()
+	defer configLoadMu.Unlock()
+
+	var strict ConfigErrors
+	configWarn = warn
+	if atomic.LoadInt32(&strictConfig) != 0 {
+		configWarn = func(warning string) {
+			msg := strings.Replace(warning, "LoadConfiguration: Warning: ", "LoadConfiguration: Error: ", 1)
+			strict = append(strict, errors.New(msg+"\n"))
+		}
+	}
+	err := load()
+	configWarn = nil
+
+	if len(strict) == 0 {
+		return err
+	}
+	log.closeFilters()
+	if errs, ok := err.(ConfigErrors); ok {
+		return append(strict, errs...)
+	}
+	if err != nil {
+		return append(strict, err)
+	}
+	return strict
+}
+
+// configWarning reports a problem which does not stop a configuration loading
+func configWarning(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	if configWarn != nil {
+		configWarn(strings.TrimSuffix(warning, "\n"))
+		return
+	}
+	fmt.Fprint(os.Stderr, warning)
+}
+
+// Load configuration from a file system, such as an embed.FS holding a
+// configuration embedded with go:embed.  The format is chosen from the
+// extension of name as by WatchConfiguration (.json, .yaml or .yml, otherwise
+// XML).  Files included by an XML configuration are read from fsys too.
+func (log Logger) LoadConfigurationFS(fsys fs.FS, name string) error {
+
+	// Open the configuration file
+	fd, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", name, err)
+	}
+	defer fd.Close()
+
+	// Load the configuration
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return log.LoadConfigurationFromReaderJSON(fd, name)
+	case ".yaml", ".yml":
+		return log.LoadConfigurationFromReaderYAML(fd, name)
+	}
+	return log.loadXMLConfiguration(fd, name, fsys, nil)
+}
+
+// Load XML configuration from a reader, reading included files from fsys, or
+// the operating system if fsys is nil, and passing warnings to warn, or
+// standard error if warn is nil
+func (log Logger) loadXMLConfiguration(r io.Reader, filename string, fsys fs.FS, warn func(warning string)) error {
+	log.closeFilters()
 
 	contents, err := ioutil.ReadAll(r)
 	if err != nil {
 		return fmt.Errorf("LoadConfiguration: Error: Could not read %q: %s\n", filename, err)
 	}
 
+	return withConfigWarnings(log, warn, func() error {
+		filters, err := parseXMLConfigurationFrom(contents, filename, fsys, nil)
+		if err != nil {
+			return err
+		}
+		return log.loadFilters(filename, filters)
+	})
+}
+
+func parseXMLConfiguration(contents []byte, filename string) ([]filterConfig, error) {
+	return parseXMLConfigurationFrom(contents, filename, nil, nil)
+}
+
+// parseXMLConfigurationFrom parses an XML configuration which was included by
+// each of the files in including, outermost first.  The filters of the files
+// named by <include file="..."/> elements come first, in order, so that the
+// filters of the including file override included filters with the same tag.
+// Relative paths are relative to the directory of the including file.
+// Included files are read from fsys, or the operating system if fsys is nil.
+func parseXMLConfigurationFrom(contents []byte, filename string, fsys fs.FS, including []string) ([]filterConfig, error) {
 	xc := new(xmlLoggerConfig)
 	if err := xml.Unmarshal(contents, xc); err != nil {
-		return fmt.Errorf("LoadConfiguration: Error: Could not parse XML configuration in %q: %s\n", filename, err)
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse XML configuration in %q: %s\n", filename, err)
 	}
 
+	var filters []filterConfig
+	chain := append(append([]string{}, including...), filename)
+	for _, inc := range xc.Include {
+		if len(inc.File) == 0 {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Required attribute %s for include missing in %s\n", "file", filename)
+		}
+		incPath := inc.File
+		switch {
+		case fsys != nil:
+			incPath = path.Join(path.Dir(filename), incPath)
+		case !filepath.IsAbs(incPath):
+			incPath = filepath.Join(filepath.Dir(filename), incPath)
+		}
+		for _, f := range chain {
+			if filepath.Clean(f) == filepath.Clean(incPath) {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not load XML configuration in %s: include cycle through %q\n", filename, inc.File)
+			}
+		}
+		var included []byte
+		var err error
+		if fsys != nil {
+			included, err = fs.ReadFile(fsys, incPath)
+		} else {
+			included, err = ioutil.ReadFile(incPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", incPath, err)
+		}
+		incFilters, err := parseXMLConfigurationFrom(included, incPath, fsys, chain)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, incFilters...)
+	}
+
+	own := make([]filterConfig, 0, len(xc.Filter))
 	for _, xmlfilt := range xc.Filter {
-		var filt LogWriter
-		var lvl Level
-		enabled := false
+		own = append(own, xmlfilt.filterConfig())
+	}
+	applyDefaultFormat(own, xc.defaultFormat(filename))
+	return mergeFilters(filters, own), nil
+}
 
-		// Check required children
-		if len(xmlfilt.Enabled) == 0 {
-			return fmt.Errorf("LoadConfiguration: Error: Required attribute %s for filter missing in %s\n", "enabled", filename)
-		} else {
-			enabled = xmlfilt.Enabled != "false"
-		}
-		if len(xmlfilt.Tag) == 0 {
-			return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "tag", filename)
-		}
-		if len(xmlfilt.Type) == 0 {
-			return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "type", filename)
-		}
-		if len(xmlfilt.Level) == 0 {
-			return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "level", filename)
-		}
-
-		switch xmlfilt.Level {
-		case "FINEST":
-			lvl = FINEST
-		case "FINE":
-			lvl = FINE
-		case "DEBUG":
-			lvl = DEBUG
-		case "TRACE":
-			lvl = TRACE
-		case "INFO":
-			lvl = INFO
-		case "WARNING":
-			lvl = WARNING
-		case "ERROR":
-			lvl = ERROR
-		case "CRITICAL":
-			lvl = CRITICAL
-		default:
-			return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter has unknown value in %s: %s\n", "level", filename, xmlfilt.Level)
-		}
-
-		switch xmlfilt.Type {
-		case "console":
-			filt, err = xmlToConsoleLogWriter(filename, xmlfilt.Property, enabled)
-		case "file":
-			filt, err = xmlToFileLogWriter(filename, xmlfilt.Property, enabled)
-		case "xml":
-			filt, err = xmlToXMLLogWriter(filename, xmlfilt.Property, enabled)
-		case "socket":
-			filt, err = xmlToSocketLogWriter(filename, xmlfilt.Property, enabled)
-		default:
-			err = fmt.Errorf("LoadConfiguration: Error: Could not load XML configuration in %s: unknown filter type \"%s\"\n", filename, xmlfilt.Type)
+// mergeFilters adds filters to base, replacing any filter of base with the
+// same tag in place
+func mergeFilters(base, filters []filterConfig) []filterConfig {
+	for _, fc := range filters {
+		replaced := false
+		for i := range base {
+			if len(fc.Tag) > 0 && base[i].Tag == fc.Tag {
+				base[i], replaced = fc, true
+				break
+			}
+		}
+		if !replaced {
+			base = append(base, fc)
+		}
+	}
+	return base
+}
+
+// Load JSON configuration.  The document holds a "filters" array whose
+// entries carry the same enabled/tag/level/type fields as the XML
+// configuration, plus a "properties" object, and may set a default "format".
+func (log Logger) LoadConfigurationJSON(filename string) error {
+
+	// Open the configuration file
+	fd, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", filename, err)
+	}
+	defer fd.Close()
+
+	// Load the configuration
+	return log.LoadConfigurationFromReaderJSON(fd, filename)
+}
+
+// Load JSON configuration from a reader
+func (log Logger) LoadConfigurationFromReaderJSON(r io.Reader, filename string) error {
+	log.closeFilters()
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not read %q: %s\n", filename, err)
+	}
+
+	return withConfigWarnings(log, nil, func() error {
+		filters, err := parseJSONConfiguration(contents, filename)
+		if err != nil {
+			return err
+		}
+		return log.loadFilters(filename, filters)
+	})
+}
+
+func parseJSONConfiguration(contents []byte, filename string) ([]filterConfig, error) {
+	jc := new(jsonLoggerConfig)
+	if err := json.Unmarshal(contents, jc); err != nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse JSON configuration in %q: %s\n", filename, err)
+	}
+
+	filters := make([]filterConfig, 0, len(jc.Filters))
+	for _, jsonfilt := range jc.Filters {
+		filters = append(filters, jsonfilt.filterConfig())
+	}
+	applyDefaultFormat(filters, trimProp(jc.Format))
+	return filters, nil
+}
+
+// Load YAML configuration.  The document holds a top-level "filters" list
+// whose items carry the same enabled/tag/level/type fields as the XML
+// configuration, plus a "properties" map, and may set a default "format".
+// YAML support needs gopkg.in/yaml.v2 and is only built with the log4go_yaml
+// tag; without it, loading a YAML configuration returns an error.
+func (log Logger) LoadConfigurationYAML(filename string) error {
+
+	// Open the configuration file
+	fd, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", filename, err)
+	}
+	defer fd.Close()
+
+	// Load the configuration
+	return log.LoadConfigurationFromReaderYAML(fd, filename)
+}
+
+// Load YAML configuration from a reader
+func (log Logger) LoadConfigurationFromReaderYAML(r io.Reader, filename string) error {
+	log.closeFilters()
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not read %q: %s\n", filename, err)
+	}
+
+	return withConfigWarnings(log, nil, func() error {
+		filters, err := parseYAMLConfiguration(contents, filename)
+		if err != nil {
+			return err
 		}
+		return log.loadFilters(filename, filters)
+	})
+}
+
+var (
+	// ConfigWatchInterval is how often WatchConfiguration checks the
+	// configuration file for changes.
+	ConfigWatchInterval = time.Second
+
+	// ConfigReloadError is called with the error when WatchConfiguration fails
+	// to reload a changed configuration file.  The previous configuration stays
+	// active.  If nil, the error is written to standard error.
+	ConfigReloadError func(err error)
+)
+
+// loadConfigurationFile loads the configuration in filename, choosing the
+// format from its extension: .json is JSON, .yaml and .yml are YAML and
+// anything else is XML.
+func (log Logger) loadConfigurationFile(filename string) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return log.LoadConfigurationJSON(filename)
+	case ".yaml", ".yml":
+		return log.LoadConfigurationYAML(filename)
+	}
+	return log.LoadConfiguration(filename)
+}
+
+// WatchConfiguration loads the configuration in filename, then checks the file
+// every ConfigWatchInterval and reloads it whenever its modification time or
+// size changes.  The format is chosen from the file extension (.json, .yaml or
+// .yml, otherwise XML).
+//
+// A reload builds the new filters first and only then swaps them into the
+// logger, closing the old writers, so logging continues uninterrupted.  If the
+// new configuration cannot be loaded, the error is passed to ConfigReloadError
+// and the previous configuration stays active.
+//
+// Returns an error if the initial load fails, otherwise a function which stops
+// watching (the logger keeps its current filters).
+func (log Logger) WatchConfiguration(filename string) (stop func(), err error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", filename, err)
+	}
+	if err := log.reloadConfiguration(filename); err != nil {
+		return nil, err
+	}
+
+	quit := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(ConfigWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+
+			cur, err := os.Stat(filename)
+			if err != nil {
+				reportReloadError(fmt.Errorf("LoadConfiguration: Error: Could not open %q for reading: %s\n", filename, err))
+				continue
+			}
+			if cur.ModTime().Equal(info.ModTime()) && cur.Size() == info.Size() {
+				continue
+			}
+			info = cur
+
+			if err := log.reloadConfiguration(filename); err != nil {
+				reportReloadError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+		})
+	}, nil
+}
+
+// reloadConfiguration loads filename into a fresh logger and, if that
+// succeeds, swaps its filters into the logger.
+func (log Logger) reloadConfiguration(filename string) error {
+	fresh := make(Logger)
+	if err := fresh.loadConfigurationFile(filename); err != nil {
+		fresh.Close()
+		return err
+	}
+	log.replaceFilters(fresh)
+	return nil
+}
+
+func reportReloadError(err error) {
+	if ConfigReloadError != nil {
+		ConfigReloadError(err)
+		return
+	}
+	fmt.Fprint(os.Stderr, err)
+}
+
+// ConfigErrors holds every problem found by ValidateConfiguration, or by
+// loading a configuration in strict mode.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, strings.TrimSuffix(err.Error(), "\n"))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the individual errors, for use with errors.Is and errors.As.
+func (errs ConfigErrors) Unwrap() []error {
+	return errs
+}
+
+// ValidateConfiguration checks the configuration in filename without opening
+// any log files or sockets.  It runs the same parsing and checks as loading the
+// configuration, with every filter treated as disabled.  The format is chosen
+// from the file extension (.json, .yaml or .yml, otherwise XML).
+//
+// Rather than stopping at the first problem, every filter is checked and all
+// of the errors found are returned together as ConfigErrors.
+func ValidateConfiguration(filename string) error {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("LoadConfiguration: Error: Could not read %q: %s\n", filename, err)
+	}
 
-		// Just so all of the required params are errored at the same time if wrong
+	return withConfigWarnings(make(Logger), nil, func() error {
+		var filters []filterConfig
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".json":
+			filters, err = parseJSONConfiguration(contents, filename)
+		case ".yaml", ".yml":
+			filters, err = parseYAMLConfiguration(contents, filename)
+		default:
+			filters, err = parseXMLConfiguration(contents, filename)
+		}
 		if err != nil {
 			return err
 		}
 
-		// If we're disabled (syntax and correctness checks only), don't add to logger
+		var errs ConfigErrors
+		log := make(Logger)
+		for _, fc := range filters {
+			if err := log.loadFilter(filename, fc, true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	})
+}
+
+// A writerFactory builds the LogWriter for a filter of some type from its
+// properties.  The filename is that of the configuration, for error messages.
+type writerFactory func(filename string, props map[string]string, enabled bool) (LogWriter, error)
+
+var (
+	writerTypesMu sync.RWMutex
+	writerTypes   = map[string]writerFactory{
+		"console": func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+			return propsToConsoleLogWriter(filename, props, enabled)
+		},
+		"file": func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+			return propsToFileLogWriter(filename, props, enabled)
+		},
+		"xml": func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+			return propsToXMLLogWriter(filename, props, enabled)
+		},
+		"json": func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+			return propsToJSONLogWriter(filename, props, enabled)
+		},
+		"socket": func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+			return propsToSocketLogWriter(filename, props, enabled)
+		},
+		"syslog": propsToSyslogLogWriter,
+		"null":   propsToNullLogWriter,
+		"http":   propsToHTTPLogWriter,
+	}
+)
+
+// RegisterWriterType makes a custom LogWriter available to configuration files
+// under the given filter type name, replacing any existing type of that name.
+// When a filter of that type is loaded, the factory is called with the
+// filter's properties; if enabled is false the configuration is only being
+// checked, and the factory should validate the properties and return a nil
+// LogWriter.  Errors returned by the factory abort the load.
+func RegisterWriterType(name string, factory func(props map[string]string, enabled bool) (LogWriter, error)) {
+	writerTypesMu.Lock()
+	defer writerTypesMu.Unlock()
+	writerTypes[name] = func(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+		return factory(props, enabled)
+	}
+}
+
+// Build the writer for a filter of the given type.  A filter of type "multi"
+// writes to the writers of each of its nested filters which is not disabled.
+func buildWriter(filename string, fc filterConfig, enabled bool) (LogWriter, error) {
+	if fc.Type == "multi" {
+		if len(fc.Children) == 0 {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Required child <%s> for multi filter missing in %s\n", "filter", filename)
+		}
+		var writers []LogWriter
+		for _, child := range fc.Children {
+			if len(child.Type) == 0 {
+				closeWriters(writers)
+				return nil, fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "type", filename)
+			}
+			w, err := buildWriter(filename, child, enabled && filterEnabled(filename, child.Enabled))
+			if err != nil {
+				closeWriters(writers)
+				return nil, err
+			}
+			if w != nil {
+				writers = append(writers, w)
+			}
+		}
 		if !enabled {
-			continue
+			return nil, nil
 		}
+		return NewMultiLogWriter(writers...), nil
+	}
+	if len(fc.Children) > 0 {
+		configWarning("LoadConfiguration: Warning: Nested filters are ignored for %s filter in %s\n", fc.Type, filename)
+	}
+
+	writerTypesMu.RLock()
+	factory, ok := writerTypes[fc.Type]
+	writerTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not load XML configuration in %s: unknown filter type \"%s\"\n", filename, fc.Type)
+	}
+	w, err := factory(filename, fc.Properties, enabled)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close writers built for a filter which failed to load
+func closeWriters(writers []LogWriter) {
+	for _, w := range writers {
+		w.Close()
+	}
+}
+
+// Validate and build the given filters, adding the enabled ones to the logger
+func (log Logger) loadFilters(filename string, filters []filterConfig) error {
+	for _, fc := range filters {
+		if err := log.loadFilter(filename, fc, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate and build a single filter, adding it to the logger if it is
+// enabled.  When validating, the filter is treated as disabled so that no
+// writer is constructed.
+func (log Logger) loadFilter(filename string, fc filterConfig, validate bool) error {
+	var filt LogWriter
+	var err error
+	enabled := false
+
+	// Check required children
+	if len(fc.Enabled) == 0 {
+		return fmt.Errorf("LoadConfiguration: Error: Required attribute %s for filter missing in %s\n", "enabled", filename)
+	} else {
+		enabled = filterEnabled(filename, fc.Enabled) && !validate
+	}
+	if len(fc.Tag) == 0 {
+		return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "tag", filename)
+	}
+	if len(fc.Type) == 0 {
+		return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "type", filename)
+	}
+	if len(fc.Level) == 0 {
+		return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter missing in %s\n", "level", filename)
+	}
+
+	lvl, ok := LevelFromString(fc.Level)
+	if !ok {
+		return fmt.Errorf("LoadConfiguration: Error: Required child <%s> for filter has unknown value in %s: %s\n", "level", filename, fc.Level)
+	}
 
-		log[xmlfilt.Tag] = &Filter{lvl, filt}
+	filt, err = buildWriter(filename, fc, enabled)
+
+	// Just so all of the required params are errored at the same time if wrong
+	if err != nil {
+		return err
+	}
+
+	// If we're disabled (syntax and correctness checks only), don't add to logger
+	if !enabled {
+		return nil
 	}
 
+	filtersMu.Lock()
+	log[fc.Tag] = &Filter{Level: lvl, LogWriter: filt}
+	filtersMu.Unlock()
+
 	return nil
 }
 
-func xmlToConsoleLogWriter(filename string, props []xmlProperty, enabled bool) (ConsoleLogWriter, error) {
+func propsToConsoleLogWriter(filename string, props map[string]string, enabled bool) (*ConsoleLogWriter, error) {
+	format := ""
+
 	// Parse properties
-	for _, prop := range props {
-		switch prop.Name {
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
+		case "format":
+			format = value
 		default:
-			fmt.Fprintf(os.Stderr, "LoadConfiguration: Warning: Unknown property \"%s\" for console filter in %s\n", prop.Name, filename)
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for console filter in %s\n", name, filename)
 		}
 	}
 
@@ -142,11 +863,23 @@ func xmlToConsoleLogWriter(filename string, props []xmlProperty, enabled bool) (
 		return nil, nil
 	}
 
-	return NewConsoleLogWriter(), nil
+	return NewConsoleLogWriter().SetFormat(format), nil
 }
 
-// Parse a number with K/M/G suffixes based on thousands (1000) or 2^10 (1024)
-func strToNumSuffix(str string, mult int) int {
+// Parse a number with K/M/G suffixes based on thousands (1000) or 2^10 (1024),
+// returning an error if it is malformed.  The suffixes KiB/MiB/GiB are always
+// based on 2^10, and KB/MB/GB on thousands.
+func strToNumSuffix(str string, mult int) (int, error) {
+	orig := str
+	if upper := strings.ToUpper(str); strings.HasSuffix(upper, "IB") {
+		mult, str = 1024, str[:len(str)-2]
+	} else if strings.HasSuffix(upper, "B") {
+		mult, str = 1000, str[:len(str)-1]
+	}
+	if len(str) < len(orig) && (len(str) < 2 || !strings.ContainsRune("KMGkmg", rune(str[len(str)-1]))) {
+		return 0, fmt.Errorf("invalid number %q", orig)
+	}
+
 	num := 1
 	if len(str) > 1 {
 		switch str[len(str)-1] {
@@ -161,37 +894,136 @@ func strToNumSuffix(str string, mult int) int {
 			str = str[0 : len(str)-1]
 		}
 	}
-	parsed, _ := strconv.Atoi(str)
-	return parsed * num
+	parsed, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", orig)
+	}
+	return parsed * num, nil
+}
+
+// Parse a file mode given as an octal string, e.g. 0640
+func parsePerm(str string) (os.FileMode, bool) {
+	perm, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, false
+	}
+	return os.FileMode(perm), true
 }
-func xmlToFileLogWriter(filename string, props []xmlProperty, enabled bool) (*FileLogWriter, error) {
+
+// Parse a duration which may also be given in days, e.g. 7d
+func parseMaxAge(str string) (time.Duration, error) {
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.Atoi(str[:len(str)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", str)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
+
+func propsToFileLogWriter(filename string, props map[string]string, enabled bool) (*FileLogWriter, error) {
 	file := ""
 	format := "[%D %T] [%L] (%S) %M"
 	maxlines := 0
 	maxsize := 0
 	daily := false
+	hourly := false
 	rotate := false
 	keepNum := 0
+	maxTotalSize := 0
+	header, trailer := "", ""
+	maxAge := time.Duration(0)
+	compress := false
+	buffersize := 0
+	flushinterval := time.Duration(0)
+	dirperm := os.FileMode(0755)
+	perm := os.FileMode(0)
+	dedup := false
+	deduphold := time.Duration(0)
+	cron := ""
+	appendSet, appending := false, false
 
 	// Parse properties
-	for _, prop := range props {
-		switch prop.Name {
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
 		case "filename":
-			file = strings.Trim(prop.Value, " \r\n")
+			file = expandEnv(filename, "file", value)
 		case "format":
-			format = strings.Trim(prop.Value, " \r\n")
+			format = expandEnv(filename, "file", value)
+		case "header":
+			header = expandEnv(filename, "file", value)
+		case "trailer":
+			trailer = expandEnv(filename, "file", value)
 		case "maxlines":
-			maxlines = strToNumSuffix(strings.Trim(prop.Value, " \r\n"), 1000)
+			var err error
+			if maxlines, err = strToNumSuffix(value, 1000); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "maxlines", filename, err)
+			}
 		case "maxsize":
-			maxsize = strToNumSuffix(strings.Trim(prop.Value, " \r\n"), 1024)
+			var err error
+			if maxsize, err = strToNumSuffix(value, 1024); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "maxsize", filename, err)
+			}
 		case "daily":
-			daily = strings.Trim(prop.Value, " \r\n") != "false"
+			daily = parseBool(filename, "file", "daily", value)
+		case "hourly":
+			hourly = parseBool(filename, "file", "hourly", value)
+		case "cron":
+			if _, err := parseCron(value); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "cron", filename, err)
+			}
+			cron = value
 		case "rotate":
-			rotate = strings.Trim(prop.Value, " \r\n") != "false"
+			rotate = parseBool(filename, "file", "rotate", value)
+		case "append":
+			appendSet, appending = true, parseBool(filename, "file", "append", value)
 		case "keepnum":
-			keepNum, _ = strconv.Atoi(strings.Trim(prop.Value, " \r\n"))
+			keepNum, _ = strconv.Atoi(value)
+		case "maxtotalsize":
+			var err error
+			if maxTotalSize, err = strToNumSuffix(value, 1024); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "maxtotalsize", filename, err)
+			}
+		case "maxage":
+			var err error
+			if maxAge, err = parseMaxAge(value); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "maxage", filename, err)
+			}
+		case "compress":
+			compress = parseBool(filename, "file", "compress", value)
+		case "buffersize":
+			var err error
+			if buffersize, err = strToNumSuffix(value, 1024); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "buffersize", filename, err)
+			}
+		case "flushinterval":
+			var err error
+			if flushinterval, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "flushinterval", filename, err)
+			}
+		case "dirperm":
+			if perm, ok := parsePerm(value); ok {
+				dirperm = perm
+			} else {
+				configWarning("LoadConfiguration: Warning: Property \"%s\" for file filter in %s is not an octal mode: %s\n", "dirperm", filename, value)
+			}
+		case "dedup":
+			dedup = parseBool(filename, "file", "dedup", value)
+		case "deduphold":
+			var err error
+			if deduphold, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for file filter in %s: %s\n", "deduphold", filename, err)
+			}
+		case "perm":
+			if p, ok := parsePerm(value); ok {
+				perm = p
+			} else {
+				configWarning("LoadConfiguration: Warning: Property \"%s\" for file filter in %s is not an octal mode: %s\n", "perm", filename, value)
+			}
 		default:
-			fmt.Fprintf(os.Stderr, "LoadConfiguration: Warning: Unknown property \"%s\" for file filter in %s\n", prop.Name, filename)
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for file filter in %s\n", name, filename)
 		}
 	}
 
@@ -205,37 +1037,81 @@ func xmlToFileLogWriter(filename string, props []xmlProperty, enabled bool) (*Fi
 		return nil, nil
 	}
 
-	flw := NewFileLogWriter(file, rotate)
+	// The writer opens its first file straight away, so create its directory
+	// with the requested mode first
+	if fname, err := Format(file, time.Now()); err == nil {
+		if err := os.MkdirAll(filepath.Dir(fname), dirperm); err != nil {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Could not create directory for file filter in %s: %s\n", filename, err)
+		}
+	}
+
+	// Appending must not rotate the existing file away as the writer opens it
+	flw := NewFileLogWriter(file, rotate && !appending)
+	if flw == nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not open %q for file filter in %s\n", file, filename)
+	}
+	if appendSet {
+		flw.SetAppend(appending).SetRotate(rotate)
+	}
+	flw.SetDirPerm(dirperm)
+	if perm != 0 {
+		flw.SetFilePerm(perm)
+	}
 	flw.SetFormat(format)
+	flw.SetHeader(header).SetTrailer(trailer)
 	flw.SetRotateLines(maxlines)
 	flw.SetRotateSize(maxsize)
 	flw.SetRotateDaily(daily)
+	flw.SetRotateHourly(hourly)
+	flw.SetRotateCron(cron)
 	flw.SetKeepNum(keepNum)
+	flw.SetMaxAge(maxAge)
+	flw.SetMaxTotalSize(maxTotalSize)
+	flw.SetCompressRotated(compress)
+	flw.SetBufferSize(buffersize)
+	flw.SetFlushInterval(flushinterval)
+	flw.SetDedup(dedup)
+	if deduphold > 0 {
+		flw.SetDedupHold(deduphold)
+	}
 	return flw, nil
 }
 
-func xmlToXMLLogWriter(filename string, props []xmlProperty, enabled bool) (*FileLogWriter, error) {
+func propsToXMLLogWriter(filename string, props map[string]string, enabled bool) (*FileLogWriter, error) {
 	file := ""
 	maxrecords := 0
 	maxsize := 0
 	daily := false
 	rotate := false
+	timestamp := XML_TIMESTAMP_DEFAULT
 
 	// Parse properties
-	for _, prop := range props {
-		switch prop.Name {
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
 		case "filename":
-			file = strings.Trim(prop.Value, " \r\n")
+			file = expandEnv(filename, "xml", value)
+		case "timestamp":
+			if _, ok := xmlTimestamps[value]; !ok {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for xml filter in %s: unknown format %q\n", "timestamp", filename, value)
+			}
+			timestamp = value
 		case "maxrecords":
-			maxrecords = strToNumSuffix(strings.Trim(prop.Value, " \r\n"), 1000)
+			var err error
+			if maxrecords, err = strToNumSuffix(value, 1000); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for xml filter in %s: %s\n", "maxrecords", filename, err)
+			}
 		case "maxsize":
-			maxsize = strToNumSuffix(strings.Trim(prop.Value, " \r\n"), 1024)
+			var err error
+			if maxsize, err = strToNumSuffix(value, 1024); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for xml filter in %s: %s\n", "maxsize", filename, err)
+			}
 		case "daily":
-			daily = strings.Trim(prop.Value, " \r\n") != "false"
+			daily = parseBool(filename, "xml", "daily", value)
 		case "rotate":
-			rotate = strings.Trim(prop.Value, " \r\n") != "false"
+			rotate = parseBool(filename, "xml", "rotate", value)
 		default:
-			fmt.Fprintf(os.Stderr, "LoadConfiguration: Warning: Unknown property \"%s\" for xml filter in %s\n", prop.Name, filename)
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for xml filter in %s\n", name, filename)
 		}
 	}
 
@@ -250,25 +1126,113 @@ func xmlToXMLLogWriter(filename string, props []xmlProperty, enabled bool) (*Fil
 	}
 
 	xlw := NewXMLLogWriter(file, rotate)
+	xlw.SetXMLTimestamp(timestamp)
 	xlw.SetRotateLines(maxrecords)
 	xlw.SetRotateSize(maxsize)
 	xlw.SetRotateDaily(daily)
 	return xlw, nil
 }
 
-func xmlToSocketLogWriter(filename string, props []xmlProperty, enabled bool) (SocketLogWriter, error) {
+func propsToJSONLogWriter(filename string, props map[string]string, enabled bool) (*FileLogWriter, error) {
+	file := ""
+	maxrecords := 0
+	maxsize := 0
+	daily := false
+	rotate := false
+
+	// Parse properties
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
+		case "filename":
+			file = expandEnv(filename, "json", value)
+		case "maxrecords":
+			var err error
+			if maxrecords, err = strToNumSuffix(value, 1000); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for json filter in %s: %s\n", "maxrecords", filename, err)
+			}
+		case "maxsize":
+			var err error
+			if maxsize, err = strToNumSuffix(value, 1024); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for json filter in %s: %s\n", "maxsize", filename, err)
+			}
+		case "daily":
+			daily = parseBool(filename, "json", "daily", value)
+		case "rotate":
+			rotate = parseBool(filename, "json", "rotate", value)
+		default:
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for json filter in %s\n", name, filename)
+		}
+	}
+
+	// Check properties
+	if len(file) == 0 {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Required property \"%s\" for json filter missing in %s\n", "filename", filename)
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !enabled {
+		return nil, nil
+	}
+
+	jlw := NewJSONLogWriter(file, rotate)
+	if jlw == nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not open %q for json filter in %s\n", file, filename)
+	}
+	jlw.SetRotateLines(maxrecords)
+	jlw.SetRotateSize(maxsize)
+	jlw.SetRotateDaily(daily)
+	return jlw, nil
+}
+
+func propsToSocketLogWriter(filename string, props map[string]string, enabled bool) (*SocketLogWriter, error) {
 	endpoint := ""
 	protocol := "udp"
+	reconnect := false
+	cafile, certfile, keyfile := "", "", ""
+	queueSize := LogBufferLength
+	overflow := Block
+	format := ""
 
 	// Parse properties
-	for _, prop := range props {
-		switch prop.Name {
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
 		case "endpoint":
-			endpoint = strings.Trim(prop.Value, " \r\n")
+			endpoint = expandEnv(filename, "socket", value)
 		case "protocol":
-			protocol = strings.Trim(prop.Value, " \r\n")
+			protocol = value
+		case "reconnect":
+			reconnect = parseBool(filename, "socket", "reconnect", value)
+		case "format":
+			format = expandEnv(filename, "socket", value)
+		case "queuesize":
+			var err error
+			if queueSize, err = strToNumSuffix(value, 1000); err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for socket filter in %s: %s\n", "queuesize", filename, err)
+			}
+			if queueSize < 0 {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Property \"%s\" for socket filter has invalid value in %s: %s\n", "queuesize", filename, value)
+			}
+		case "overflow":
+			switch value {
+			case "block":
+				overflow = Block
+			case "dropnewest":
+				overflow = DropNewest
+			case "dropoldest":
+				overflow = DropOldest
+			default:
+				return nil, fmt.Errorf("LoadConfiguration: Error: Property \"%s\" for socket filter has unknown value in %s: %s\n", "overflow", filename, value)
+			}
+		case "cafile":
+			cafile = value
+		case "certfile":
+			certfile = value
+		case "keyfile":
+			keyfile = value
 		default:
-			fmt.Fprintf(os.Stderr, "LoadConfiguration: Warning: Unknown property \"%s\" for file filter in %s\n", prop.Name, filename)
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for file filter in %s\n", name, filename)
 		}
 	}
 
@@ -276,11 +1240,58 @@ func xmlToSocketLogWriter(filename string, props []xmlProperty, enabled bool) (S
 	if len(endpoint) == 0 {
 		return nil, fmt.Errorf("LoadConfiguration: Error: Required property \"%s\" for file filter missing in %s\n", "endpoint", filename)
 	}
+	switch protocol {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram", "tls":
+	default:
+		return nil, fmt.Errorf("LoadConfiguration: Error: Property \"%s\" for socket filter has unknown value in %s: %s\n", "protocol", filename, protocol)
+	}
+	if (len(certfile) == 0) != (len(keyfile) == 0) {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Properties \"certfile\" and \"keyfile\" for socket filter must be given together in %s\n", filename)
+	}
 
 	// If it's disabled, we're just checking syntax
 	if !enabled {
 		return nil, nil
 	}
 
-	return NewSocketLogWriter(protocol, endpoint), nil
+	var slw *SocketLogWriter
+	if protocol == "tls" {
+		cfg, err := loadTLSConfig(cafile, certfile, keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Could not load TLS configuration for socket filter in %s: %s\n", filename, err)
+		}
+		slw, err = NewTLSSocketLogWriter(protocol, endpoint, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConfiguration: Error: Could not connect socket filter in %s: %s\n", filename, err)
+		}
+	} else {
+		slw = NewSocketLogWriter(protocol, endpoint)
+	}
+	slw.SetReconnect(reconnect).SetQueueSize(queueSize).SetOverflow(overflow).SetFormat(format)
+	return slw, nil
+}
+
+// loadTLSConfig builds the client TLS configuration for a socket filter from
+// PEM files.  The CA file replaces the system roots, and the certificate and
+// key are presented to the server.
+func loadTLSConfig(cafile, certfile, keyfile string) (*tls.Config, error) {
+	cfg := new(tls.Config)
+	if len(cafile) > 0 {
+		pem, err := ioutil.ReadFile(cafile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cafile)
+		}
+	}
+	if len(certfile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
@@ -0,0 +1,87 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"context"
+	"sync"
+)
+
+// A contextField names a context value to attach to records
+type contextField struct {
+	key  interface{}
+	name string
+}
+
+var (
+	contextFieldsMu sync.RWMutex
+	contextFields   []contextField
+	contextFuncs    []func(ctx context.Context) map[string]interface{}
+)
+
+// RegisterContextField makes LogCtx attach the value stored in a context under
+// key as the record field fieldName, e.g. a request ID.  Registering the same
+// key again changes its field name.  Typically called during initialization.
+func RegisterContextField(key interface{}, fieldName string) {
+	contextFieldsMu.Lock()
+	defer contextFieldsMu.Unlock()
+
+	for i := range contextFields {
+		if contextFields[i].key == key {
+			contextFields[i].name = fieldName
+			return
+		}
+	}
+	contextFields = append(contextFields, contextField{key: key, name: fieldName})
+}
+
+// RegisterContextFunc makes LogCtx attach the fields returned by fn for a
+// context, for values which cannot be looked up by a key alone, e.g. the IDs
+// of a tracing span.  fn returns nil if ctx has none of its fields.  Typically
+// called during initialization.
+func RegisterContextFunc(fn func(ctx context.Context) map[string]interface{}) {
+	contextFieldsMu.Lock()
+	defer contextFieldsMu.Unlock()
+
+	contextFuncs = append(contextFuncs, fn)
+}
+
+// contextValues returns the registered fields which are present in ctx
+func contextValues(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+
+	contextFieldsMu.RLock()
+	defer contextFieldsMu.RUnlock()
+
+	var fields map[string]interface{}
+	for _, cf := range contextFields {
+		if val := ctx.Value(cf.key); val != nil {
+			if fields == nil {
+				fields = make(map[string]interface{}, len(contextFields)+2)
+			}
+			fields[cf.name] = val
+		}
+	}
+	for _, fn := range contextFuncs {
+		for name, val := range fn(ctx) {
+			if fields == nil {
+				fields = make(map[string]interface{}, len(contextFields)+2)
+			}
+			fields[name] = val
+		}
+	}
+	return fields
+}
+
+// LogCtx logs a formatted log message at the given log level, using the caller
+// as its source, with the values of the registered context fields (see
+// RegisterContextField and RegisterContextFunc) found in ctx attached as
+// fields.  Fields missing from ctx are omitted.
+func (log Logger) LogCtx(ctx context.Context, lvl Level, format string, args ...interface{}) {
+	if log.skip(lvl) {
+		return
+	}
+	log.intLogFields(lvl, contextValues(ctx), format, args...)
+}
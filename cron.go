@@ -0,0 +1,142 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cronSchedule is a parsed cron spec; each field is a bit set of the values
+// which match.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Whether dom and dow were *; if neither was, a day matching either fires
+	domStar, dowStar bool
+}
+
+// The range of values for each field of a cron spec
+var cronFields = [...]struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both Sunday
+}
+
+// parseCron parses a cron spec of five fields, "minute hour day-of-month month
+// day-of-week", or just the first two, e.g. "0 0,12" for midnight and noon.
+// Each field is *, a number, a range such as 1-5, or a comma separated list of
+// these, and any but a number may have a step such as */15.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 2:
+		fields = append(fields, "*", "*", "*")
+	case 5:
+	default:
+		return nil, fmt.Errorf("cron spec %q: expected 2 or 5 fields, found %d", spec, len(fields))
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %s %s", spec, cronFields[i].name, err)
+		}
+		bits[i] = set
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1 << 0
+	}
+
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one field of a cron spec into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%q: invalid step", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		switch i := strings.IndexByte(rng, '-'); {
+		case rng == "*":
+		case i >= 0:
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(rng[:i])
+			hi, err2 = strconv.Atoi(rng[i+1:])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("%q: invalid range", part)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil || step != 1 {
+				return 0, fmt.Errorf("%q: invalid value", part)
+			}
+			lo, hi = n, n
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q: out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// matchesDay reports whether the schedule fires on the day of t
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t at which the schedule fires, or the
+// zero time if it never does (e.g. on February 30th).
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Give up after looking through five years, which covers leap days
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,31 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build log4go_release
+
+package log4go
+
+// Built with the log4go_release tag, the levels below TRACE are compiled out:
+// Finest, Fine and Debug do nothing, so the compiler can inline them away along
+// with the evaluation of any arguments without side effects, and records below
+// TRACE logged by other means, e.g. Logf, are dropped.
+const minCompiledLevel = TRACE
+
+// Finest does nothing in release builds.  See Debug.
+func (log Logger) Finest(arg0 interface{}, args ...interface{}) {}
+
+// Fine does nothing in release builds.  See Debug.
+func (log Logger) Fine(arg0 interface{}, args ...interface{}) {}
+
+// Debug does nothing in release builds, which are built with the
+// log4go_release tag.  Arguments with side effects, such as function calls,
+// are still evaluated; pass a closure to avoid that in any build.
+func (log Logger) Debug(arg0 interface{}, args ...interface{}) {}
+
+// Wrapper for (*Logger).Finest, which does nothing in release builds
+func Finest(arg0 interface{}, args ...interface{}) {}
+
+// Wrapper for (*Logger).Fine, which does nothing in release builds
+func Fine(arg0 interface{}, args ...interface{}) {}
+
+// Wrapper for (*Logger).Debug, which does nothing in release builds
+func Debug(arg0 interface{}, args ...interface{}) {}
@@ -0,0 +1,45 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build log4go_release
+
+package log4go
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReleaseBuildDebugLevels(t *testing.T) {
+	mem := NewMemoryLogWriter(10).SetFormat("%M")
+	log := make(Logger)
+	log.AddFilter("memory", FINEST, mem)
+	defer log.Close()
+
+	saved := Global
+	Global = log
+	defer func() { Global = saved }()
+
+	called := false
+	closure := func() string {
+		called = true
+		return "closure"
+	}
+	log.Finest("finest")
+	log.Fine("fine")
+	log.Debug(closure)
+	Finest("finest")
+	Fine("fine")
+	Debug(closure)
+	log.Logf(DEBUG, "logf")
+	log.Trace("trace")
+
+	if got := fmt.Sprint(mem.Dump()); got != "[trace]" {
+		t.Errorf("Expected only the trace record to be written, found %s", got)
+	}
+	if called {
+		t.Errorf("Expected the closure not to be called")
+	}
+	if log.Enabled(DEBUG) || !log.Enabled(TRACE) {
+		t.Errorf("Enabled(DEBUG) = %v, Enabled(TRACE) = %v; want false, true", log.Enabled(DEBUG), log.Enabled(TRACE))
+	}
+}
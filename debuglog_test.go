@@ -0,0 +1,268 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !log4go_release
+
+package log4go
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// These tests log below TRACE, which the log4go_release tag compiles out
+
+func TestGlobalFunctions(t *testing.T) {
+	saved := Global
+	Global = make(Logger)
+	defer func() { Global = saved }()
+
+	mem := NewMemoryLogWriter(10).SetFormat("%S|%L %M")
+	AddFilter("memory", FINEST, mem)
+	Debug("debug %d", 1)
+	Info("info")
+	Warn("warn")
+	Error(errors.New("error"))
+	Critical(func() string { return "critical" })
+	Finest("finest")
+
+	want := []string{
+		"DEBG debug 1",
+		"INFO info",
+		"WARN warn",
+		"EROR error",
+		"CRIT critical",
+		"FNST finest",
+	}
+	var got []string
+	for _, line := range mem.Dump() {
+		parts := strings.SplitN(line, "|", 2)
+		if !strings.Contains(parts[0], ".TestGlobalFunctions:") {
+			t.Errorf("Expected the source to be the caller, found %q", parts[0])
+		}
+		got = append(got, parts[len(parts)-1])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Global logger received:\n%q\nwant:\n%q", got, want)
+	}
+	Close()
+	if len(Global) != 0 {
+		t.Errorf("Expected Close to remove the global filters, found %d", len(Global))
+	}
+
+	// The global logger can be configured from a file
+	logfile := filepath.Join(t.TempDir(), "global.log")
+	configfile := filepath.Join(t.TempDir(), "global.xml")
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>WARNING</level>
+    <property name="filename">` + logfile + `</property>
+    <property name="format">%M</property>
+  </filter>
+</logging>`
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	if err := LoadGlobalConfiguration(configfile); err != nil {
+		t.Fatalf("LoadGlobalConfiguration: %s", err)
+	}
+	Info("not written")
+	Warn("written")
+	Close()
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "written\n" {
+		t.Errorf("Unexpected log contents: %q", contents)
+	}
+}
+
+func TestSetGlobalMinLevel(t *testing.T) {
+	info := NewMemoryLogWriter(10).SetFormat("%M")
+	debug := NewMemoryLogWriter(10).SetFormat("%M")
+	log := make(Logger)
+	log.AddFilter("info", INFO, info)
+	log.AddFilter("debug", DEBUG, debug)
+	defer log.Close()
+
+	log.Debug("before")
+	log.SetGlobalMinLevel(DEBUG)
+	if !log.Enabled(DEBUG) || log.Enabled(FINE) {
+		t.Errorf("Expected DEBUG, but not FINE, to be enabled with the floor at DEBUG")
+	}
+	log.Debug("during")
+	log.Fine("below the floor")
+	log.ClearGlobalMinLevel()
+	log.Debug("after")
+
+	if got := fmt.Sprint(info.Dump()); got != "[during]" {
+		t.Errorf("Expected the INFO filter to write only the record logged with the floor set, found %s", got)
+	}
+	if got := fmt.Sprint(debug.Dump()); got != "[before during after]" {
+		t.Errorf("Expected the DEBUG filter to be unaffected, found %s", got)
+	}
+	if lvl, _ := log.GetLevel("info"); lvl != INFO {
+		t.Errorf("Expected the filter's own level to be unchanged, found %v", lvl)
+	}
+}
+
+func TestSetHook(t *testing.T) {
+	log := make(Logger)
+	log.AddFilter("null", DEBUG, NewNullLogWriter())
+	defer log.Close()
+
+	var mu sync.Mutex
+	counts := make(map[Level]int)
+	log.SetHook(func(r *LogRecord) {
+		mu.Lock()
+		counts[r.Level]++
+		mu.Unlock()
+		r.Message = "changed by hook"
+	})
+
+	rw := &recordingWriter{}
+	log.AddFilter("recorder", INFO, rw)
+
+	log.Debug("debug")
+	log.Info("info 1")
+	log.Info("info 2")
+	log.Error("error")
+	log.Fine("fine") // below every filter, so not dispatched
+
+	mu.Lock()
+	got := counts
+	mu.Unlock()
+	want := map[Level]int{DEBUG: 1, INFO: 2, ERROR: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hook counted %v, want %v", got, want)
+	}
+	if msgs := rw.messages(); !reflect.DeepEqual(msgs, []string{"info 1", "info 2", "error"}) {
+		t.Errorf("writer got %q; the hook should not change its records", msgs)
+	}
+
+	log.SetHook()
+	log.Info("info 3")
+	if counts[INFO] != 2 {
+		t.Errorf("hook still called after being removed")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger)
+	l.AddFilter("rec", INFO, w)
+
+	if err := l.SetLevel("missing", DEBUG); err == nil {
+		t.Errorf("SetLevel: Expected error for unknown tag")
+	}
+	if _, ok := l.GetLevel("missing"); ok {
+		t.Errorf("GetLevel: Expected unknown tag to be reported missing")
+	}
+
+	l.Debug("suppressed")
+	if msgs := w.messages(); len(msgs) != 0 {
+		t.Fatalf("Expected DEBUG to be suppressed at INFO, got %v", msgs)
+	}
+
+	// Raise the verbosity while other goroutines are logging
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Info("busy")
+			}
+		}()
+	}
+	if err := l.SetLevel("rec", DEBUG); err != nil {
+		t.Fatalf("SetLevel: %s", err)
+	}
+	wg.Wait()
+
+	if lvl, ok := l.GetLevel("rec"); !ok || lvl != DEBUG {
+		t.Errorf("GetLevel: Expected DEBUG, found %v (exists: %v)", lvl, ok)
+	}
+
+	l.Debug("passed")
+	msgs := w.messages()
+	if len(msgs) != 401 || msgs[len(msgs)-1] != "passed" {
+		t.Errorf("Expected DEBUG to pass after SetLevel, got %d messages ending with %q", len(msgs), msgs[len(msgs)-1])
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	rw := &recordingWriter{}
+	log := make(Logger)
+	log.AddFilter("sampled", FINEST, rw)
+	log["sampled"].SetSampleRate(10)
+	defer log.Close()
+
+	for i := 0; i < 100; i++ {
+		log.Debug("record %d", i)
+	}
+	msgs := rw.messages()
+	if len(msgs) != 10 {
+		t.Fatalf("got %d records, want 10", len(msgs))
+	}
+	if msgs[0] != "record 0" || msgs[9] != "record 90" {
+		t.Errorf("got %q, want every 10th record", msgs)
+	}
+
+	for i := 0; i < 5; i++ {
+		log.Critical("critical %d", i)
+	}
+	if got := len(rw.messages()); got != 15 {
+		t.Errorf("got %d records after 5 CRITICAL, want 15", got)
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	const (
+		expected = "91d8886ea61cf15834996856d9b7e5cb"
+	)
+
+	// Unbuffered output
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	l := make(Logger)
+
+	// Delete and open the output log without a timestamp (for a constant md5sum)
+	l.AddFilter("file", FINEST, NewFileLogWriter(testLogFile, false).SetFormat("[%L] %M"))
+	defer os.Remove(testLogFile)
+
+	// Send some log messages
+	l.Log(CRITICAL, "testsrc1", fmt.Sprintf("This message is level %d", int(CRITICAL)))
+	l.Logf(ERROR, "This message is level %v", ERROR)
+	l.Logf(WARNING, "This message is level %s", WARNING)
+	l.Logc(INFO, func() string { return "This message is level INFO" })
+	l.Trace("This message is level %d", int(TRACE))
+	l.Debug("This message is level %s", DEBUG)
+	l.Fine(func() string { return fmt.Sprintf("This message is level %v", FINE) })
+	l.Finest("This message is level %v", FINEST)
+	l.Finest(FINEST, "is also this message's level")
+
+	l.Close()
+
+	contents, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		t.Fatalf("Could not read output log: %s", err)
+	}
+
+	sum := md5.New()
+	sum.Write(contents)
+	if sumstr := hex.EncodeToString(sum.Sum(nil)); sumstr != expected {
+		t.Errorf("--- Log Contents:\n%s---", string(contents))
+		t.Fatalf("Checksum does not match: %s (expecting %s)", sumstr, expected)
+	}
+}
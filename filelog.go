@@ -3,19 +3,47 @@
 package log4go
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// This log writer sends output to a file
+// An Action tells a FileLogWriter what to do about a record it failed to write
+type Action int
+
+const (
+	Retry   Action = iota // Try writing the record again after a short delay
+	Drop                  // Drop the record and carry on with the next
+	Disable               // Drop the record and every one after it
+)
+
+// Delay before a FileLogWriter retries a record it failed to write
+var fileRetryDelay = 100 * time.Millisecond
+
+// This log writer sends output to a file.  Records are formatted and written,
+// and files rotated, by a single goroutine, so each record is written whole
+// however many goroutines are logging; flushes and rotations requested with
+// Flush and Rotate are done by the same goroutine between records.
 type FileLogWriter struct {
-	rec chan *LogRecord
-	rot chan bool
+	rec  chan *LogRecord
+	rot  chan chan bool
+	fl   chan chan error
+	done chan bool
+
+	closeOnce sync.Once
 
 	// The opened file
 	filename string
@@ -24,8 +52,13 @@ type FileLogWriter struct {
 	// The logging format
 	format string
 
-	// File header/trailer
+	// The time layout for %T, if not the default
+	timeFormat string
+
+	// File header/trailer, and whether the open file has yet to have its
+	// header written
 	header, trailer string
+	headerDue       bool
 
 	// Rotate at linecount
 	maxlines          int
@@ -39,21 +72,127 @@ type FileLogWriter struct {
 	daily          bool
 	daily_opendate int
 
+	// Rotate hourly
+	hourly          bool
+	hourly_opentime time.Time
+
+	// Rotate on a cron schedule, next at cron_next
+	cron      *cronSchedule
+	cron_next time.Time
+
+	// When the open file was started: when it was opened, or when it was
+	// last written if it already had records in it
+	opened   time.Time
+	existing bool
+
+	// The clock
+	now func() time.Time
+
 	// Keep old logfiles (.001, .002, etc)
 	rotate bool
 
+	// Name old logfiles with this pattern of strftime directives instead
+	namePattern string
+
+	// Reopen the file if it is moved or deleted
+	reopenIfMissing bool
+
+	// Truncate files when they are opened, rather than append to them
+	truncate bool
+
+	// Terminates each record, header and trailer in place of "\n"
+	lineSep string
+
+	// Escape records for XML output
+	xml bool
+
+	// Where to render times, if not in the records' own location
+	loc *time.Location
+
 	// Delete older files, keeping at most this many
 	keepNum int
+
+	// Delete older files, keeping none older than this
+	maxAge time.Duration
+
+	// Delete the oldest files while the files total more than this many bytes
+	maxTotalSize int64
+
+	// Gzip old logfiles after they are rotated
+	compress   bool
+	compressWG sync.WaitGroup
+
+	// Drop the oldest queued record rather than block when the queue is full
+	async   bool
+	dropped int64
+
+	// Records written, and records lost to errors
+	written, errored int64
+
+	// What to do about write errors, and whether writing has been disabled
+	onError  func(error) Action
+	disabled bool
+
+	// The error which stopped the writer, if any
+	stopErr error
+
+	// Buffer writes, flushing them periodically
+	buf           *bufio.Writer
+	bufferSize    int
+	flushInterval time.Duration
+	flushTicker   *time.Ticker
+
+	// Permissions for directories created to hold the file
+	dirPerm os.FileMode
+
+	// Permissions for log files, if not the default
+	filePerm os.FileMode
+
+	// A symlink kept pointing at the open file
+	symlink string
+
+	// Collapse consecutive identical records into a repeat count
+	dedup      bool
+	dedupHold  time.Duration
+	dedupKey   string
+	dedupLast  *LogRecord
+	dedupCount int
+	dedupTimer *time.Timer
 }
 
-// This is the FileLogWriter's output method
+// This is the FileLogWriter's output method.  This will block if the output
+// buffer is full, unless the writer was created with NewBufferedFileLogWriter.
 func (w *FileLogWriter) LogWrite(rec *LogRecord) {
-	w.rec <- rec
+	if !w.async {
+		w.rec <- rec
+		return
+	}
+
+	for {
+		select {
+		case w.rec <- rec:
+			return
+		default:
+		}
+
+		// The queue is full, so make room by dropping the oldest record
+		select {
+		case old := <-w.rec:
+			old.release()
+			atomic.AddInt64(&w.dropped, 1)
+		default:
+		}
+	}
 }
 
+// Close stops the writer, waiting for any pending records to be written and
+// any rotated files to be compressed.  Calling it again does nothing.
 func (w *FileLogWriter) Close() {
-	close(w.rec)
-	w.file.Sync()
+	w.closeOnce.Do(func() {
+		close(w.rec)
+		<-w.done
+	})
+	w.compressWG.Wait()
 }
 
 // NewFileLogWriter creates a new LogWriter which writes to the given file and
@@ -61,33 +200,37 @@ func (w *FileLogWriter) Close() {
 //
 // If rotate is true, any time a new log file is opened, the old one is renamed
 // with a .### extension to preserve it.  The various Set* methods can be used
-// to configure log rotation based on lines, size, and daily.
+// to configure log rotation based on lines, size, daily, and hourly.
 //
 // The standard log-line format is:
 //   [%D %T] [%L] (%S) %M
 func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
+	return newFileLogWriter(fname, rotate, LogBufferLength, false)
+}
+
+// NewBufferedFileLogWriter creates a FileLogWriter like NewFileLogWriter, but
+// whose LogWrite never blocks.  Records are queued for the writer's goroutine
+// to format and write, up to queueSize of them; once the queue is full the
+// oldest queued record is dropped to make room (see Dropped).  Close writes
+// everything still queued before returning.
+func NewBufferedFileLogWriter(fname string, rotate bool, queueSize int) *FileLogWriter {
+	return newFileLogWriter(fname, rotate, queueSize, true)
+}
+
+func newFileLogWriter(fname string, rotate bool, queueSize int, async bool) *FileLogWriter {
 	w := &FileLogWriter{
-		rec:      make(chan *LogRecord, LogBufferLength),
-		rot:      make(chan bool),
+		rec:      make(chan *LogRecord, queueSize),
+		rot:      make(chan chan bool),
+		fl:       make(chan chan error),
+		done:     make(chan bool),
 		filename: fname,
 		format:   "[%D %T] [%L] (%S) %M",
 		rotate:   rotate,
-	}
+		async:    async,
+		dirPerm:  0755,
+		now:      time.Now,
 
-	//check if the file exists ... create if not
-	if _, err := os.Stat(fname); err != nil {
-		if os.IsNotExist(err) {
-			fPath := filepath.Dir(fname)
-			if _, err := os.Stat(fPath); err != nil {
-				if os.IsNotExist(err) {
-					//create the directory
-					if e := os.Mkdir(fPath, os.ModePerm); e != nil {
-						fmt.Errorf("failed to create directory: %v", e)
-					}
-				}
-			}
-			os.Create(fname)
-		}
+		dedupHold: 30 * time.Second,
 	}
 
 	// open the file for the first time
@@ -98,44 +241,63 @@ func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 
 	go func() {
 		defer func() {
+			if w.flushTicker != nil {
+				w.flushTicker.Stop()
+			}
 			if w.file != nil {
-				fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
+				w.writeRepeated()
+				w.writeHeader()
+				w.flush()
+				fmt.Fprint(w.file, w.formatLine(w.trailer, w.now()))
 				w.file.Close()
 			}
+			close(w.done)
 		}()
 
 		for {
+			var flush, repeated <-chan time.Time
+			if w.flushTicker != nil {
+				flush = w.flushTicker.C
+			}
+			if w.dedupTimer != nil {
+				repeated = w.dedupTimer.C
+			}
+
 			select {
-			case <-w.rot:
-				if err := w.intRotate(); err != nil {
+			case <-repeated:
+				w.dedupTimer = nil
+				if err := w.writeRepeated(); err != nil {
+					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+					w.stopErr = err
+					return
+				}
+			case <-flush:
+				if err := w.flush(); err != nil {
+					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+				}
+			case flushed := <-w.fl:
+				err := w.writeQueued()
+				if err == nil {
+					err = w.flush()
+				}
+				flushed <- err
+			case rotated := <-w.rot:
+				err := w.rotateQueued()
+				close(rotated)
+				if err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+					w.stopErr = err
 					return
 				}
 			case rec, ok := <-w.rec:
 				if !ok {
 					return
 				}
-				now := time.Now()
-				if (w.maxlines > 0 && w.maxlines_curlines >= w.maxlines) ||
-					(w.maxsize > 0 && w.maxsize_cursize >= w.maxsize) ||
-					(w.daily && now.Day() != w.daily_opendate) {
-					if err := w.intRotate(); err != nil {
-						fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
-						return
-					}
-				}
-
-				// Perform the write
-				n, err := fmt.Fprint(w.file, FormatLogRecord(w.format, rec))
-				if err != nil {
+				if err := w.handle(rec); err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+					w.stopErr = err
 					return
 				}
-				w.file.Sync()
-
-				// Update the counts
-				w.maxlines_curlines++
-				w.maxsize_cursize += n
 			}
 		}
 	}()
@@ -143,21 +305,318 @@ func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 	return w
 }
 
-// Request that the logs rotate
+// handle writes a record, applying the error policy if it fails.  Without a
+// policy the error is returned.
+func (w *FileLogWriter) handle(rec *LogRecord) error {
+	// A record which may be kept as dedupLast is never released
+	if !w.dedup {
+		defer rec.release()
+	}
+
+	for {
+		if w.disabled {
+			atomic.AddInt64(&w.dropped, 1)
+			return nil
+		}
+		err := w.write(rec)
+		if err == nil {
+			return nil
+		}
+		if w.onError == nil {
+			return err
+		}
+		switch w.onError(err) {
+		case Retry:
+			time.Sleep(fileRetryDelay)
+			continue
+		case Disable:
+			w.disabled = true
+		}
+		return nil
+	}
+}
+
+// write writes a record to the file, unless it repeats the last one
+func (w *FileLogWriter) write(rec *LogRecord) error {
+	if w.dedup {
+		// Compare records as formatted, but without their time
+		key := formatLogRecord(w.format, &LogRecord{
+			Level:   rec.Level,
+			Source:  rec.Source,
+			Message: rec.Message,
+			Fields:  rec.Fields,
+			file:    rec.file,
+		}, w.timeFormat)
+		if w.dedupLast != nil && key == w.dedupKey {
+			w.dedupLast = rec
+			w.dedupCount++
+			if w.dedupTimer == nil {
+				w.dedupTimer = time.NewTimer(w.dedupHold)
+			}
+			return nil
+		}
+		if err := w.writeRepeated(); err != nil {
+			return err
+		}
+		w.dedupKey, w.dedupLast = key, rec
+	}
+	return w.writeRecord(rec)
+}
+
+// writeRepeated writes the "last message repeated" line for any records held
+// back as duplicates
+func (w *FileLogWriter) writeRepeated() error {
+	if w.dedupTimer != nil {
+		w.dedupTimer.Stop()
+		w.dedupTimer = nil
+	}
+	if w.dedupCount == 0 {
+		return nil
+	}
+
+	last := w.dedupLast
+	rec := &LogRecord{
+		Level:   last.Level,
+		Created: last.Created,
+		Source:  last.Source,
+		Message: fmt.Sprintf("last message repeated %d times", w.dedupCount),
+		file:    last.file,
+	}
+	w.dedupCount = 0
+	return w.writeRecord(rec)
+}
+
+// writeRecord writes a record to the file, rotating first if it is due
+func (w *FileLogWriter) writeRecord(rec *LogRecord) error {
+	if w.reopenIfMissing && w.missing() {
+		if err := w.reopen(); err != nil {
+			atomic.AddInt64(&w.errored, 1)
+			return err
+		}
+	}
+
+	// Count the lines the record takes, so that a file never holds more than
+	// maxlines of them unless a single record does
+	rec = recordIn(rec, w.loc)
+	if w.xml {
+		rec = xmlEscapeRecord(rec)
+	}
+	line := formatLogRecord(w.format, rec, w.timeFormat)
+	lines := strings.Count(line, "\n")
+	if lines == 0 {
+		lines = 1
+	}
+	line = withLineSeparator(line, w.lineSep)
+
+	now := w.now()
+	if (w.maxlines > 0 && w.maxlines_curlines > 0 && w.maxlines_curlines+lines > w.maxlines) ||
+		(w.maxsize > 0 && w.maxsize_cursize >= w.maxsize) ||
+		(w.daily && now.Day() != w.daily_opendate) ||
+		(w.hourly && !sameHour(now, w.hourly_opentime)) ||
+		(w.cron != nil && !w.cron_next.IsZero() && !now.Before(w.cron_next)) {
+		if err := w.intRotate(); err != nil {
+			atomic.AddInt64(&w.errored, 1)
+			return err
+		}
+	}
+
+	// Start buffering once it has been asked for
+	if w.buf == nil && (w.bufferSize > 0 || w.flushInterval > 0) {
+		w.buf = bufio.NewWriterSize(w.file, w.bufferSize)
+		interval := w.flushInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		w.flushTicker = time.NewTicker(interval)
+	}
+
+	// Perform the write
+	if err := w.writeHeader(); err != nil {
+		atomic.AddInt64(&w.errored, 1)
+		return err
+	}
+	n, err := io.WriteString(w.out(), line)
+	if err != nil {
+		atomic.AddInt64(&w.errored, 1)
+		return err
+	}
+	atomic.AddInt64(&w.written, 1)
+
+	// Don't leave errors sitting in the buffer in case we crash
+	if w.buf == nil || rec.Level >= ERROR {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	// Update the counts
+	w.maxlines_curlines += lines
+	w.maxsize_cursize += n
+	return nil
+}
+
+// out returns where records are written: the buffer, if there is one,
+// otherwise the file
+func (w *FileLogWriter) out() io.Writer {
+	if w.buf != nil {
+		return w.buf
+	}
+	return w.file
+}
+
+// writeHeader writes the header to the open file if it has yet to be written,
+// counting it towards the file's size but not its lines.  It is written by the
+// writer's goroutine when the file is first written to, or closed, so that
+// SetHeader can be called after the file is opened.
+func (w *FileLogWriter) writeHeader() error {
+	if !w.headerDue {
+		return nil
+	}
+	w.headerDue = false
+	n, err := io.WriteString(w.out(), w.formatLine(w.header, w.now()))
+	w.maxsize_cursize += n
+	return err
+}
+
+// rotateQueued writes the records queued before a rotation was requested to
+// the old file, then rotates.  Rotate waits until this is done, so no more
+// can arrive from its caller in the meantime.
+func (w *FileLogWriter) rotateQueued() error {
+	if err := w.writeQueued(); err != nil {
+		return err
+	}
+	if err := w.writeRepeated(); err != nil {
+		return err
+	}
+	return w.intRotate()
+}
+
+// writeQueued writes the records which are already queued
+func (w *FileLogWriter) writeQueued() error {
+drain:
+	for n := len(w.rec); n > 0; n-- {
+		select {
+		case rec, ok := <-w.rec:
+			if !ok {
+				break drain
+			}
+			if err := w.handle(rec); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	return nil
+}
+
+// flush writes out any buffered records and syncs the file
+func (w *FileLogWriter) flush() error {
+	if w.buf != nil {
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	w.file.Sync()
+	return nil
+}
+
+// Dropped returns the number of records discarded because the queue of a
+// buffered writer was full.
+func (w *FileLogWriter) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// Flush writes out the records logged before the call, including any held in
+// the write buffer, and syncs the file.  It returns once they are on disk.  If
+// a write error has stopped the writer, that error is returned.
+func (w *FileLogWriter) Flush() error {
+	flushed := make(chan error, 1)
+	select {
+	case w.fl <- flushed:
+		return <-flushed
+	case <-w.done:
+		return w.stopErr
+	}
+}
+
+// Stats returns the number of records written, dropped because the queue of a
+// buffered writer was full, and lost to write errors.
+func (w *FileLogWriter) Stats() WriterStats {
+	return WriterStats{
+		Written: atomic.LoadInt64(&w.written),
+		Dropped: atomic.LoadInt64(&w.dropped),
+		Errors:  atomic.LoadInt64(&w.errored),
+	}
+}
+
+// Request that the logs rotate, waiting until the new file is open.  Records
+// logged before the call are written to the old file and records logged after
+// it to the new one.
 func (w *FileLogWriter) Rotate() {
-	w.rot <- true
+	rotated := make(chan bool)
+	w.rot <- rotated
+	<-rotated
 }
 
-// If this is called in a threaded context, it MUST be synchronized
+// RotateOnSignal rotates the logs whenever the process receives sig, e.g.
+// syscall.SIGHUP from logrotate.  Rotation is done by the writer's goroutine,
+// as with Rotate, so it is safe while other goroutines are logging.  Call the
+// returned function to stop listening; listening also stops when the writer
+// is closed.
+func (w *FileLogWriter) RotateOnSignal(sig os.Signal) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sig)
+
+	quit := make(chan bool)
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(quit)
+		})
+	}
+
+	go func() {
+		defer stop()
+		for {
+			select {
+			case <-sigs:
+				rotated := make(chan bool)
+				select {
+				case w.rot <- rotated:
+					<-rotated
+				case <-w.done:
+					return
+				}
+			case <-quit:
+				return
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// If this is called in a threaded context, it MUST be synchronized.  The
+// writer's goroutine is the only caller once the writer is running, so no
+// record can be written while the file is being replaced.
+//
+// The current file is renamed to its rotated name and a fresh file is opened
+// at the original path; nothing is copied or truncated, so anyone still
+// reading the old file sees it complete.
 func (w *FileLogWriter) intRotate() error {
 	// Close any log file that may be open
 	if w.file != nil {
-		fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
+		w.writeHeader()
+		w.flush()
+		fmt.Fprint(w.file, w.formatLine(w.trailer, w.now()))
 		w.file.Close()
 	}
 
 	// Apply any time parameters in the filename
-	filename, err := Format(w.filename, time.Now())
+	filename, err := Format(w.filename, w.now())
 	if err != nil {
 		return err
 	}
@@ -165,21 +624,38 @@ func (w *FileLogWriter) intRotate() error {
 	// If we are keeping log files, move it to the next available number
 	if w.rotate {
 		// Delete old files
-		if w.keepNum > 0 {
+		if w.keepNum > 0 || w.maxAge > 0 || w.maxTotalSize > 0 {
 			w.DeleteOldFiles()
 		}
 
 		_, err := os.Lstat(filename)
 		if err == nil { // file exists
+			// Hourly logs are named for the hour they cover
+			base := filename
+			if w.hourly && !w.hourly_opentime.IsZero() {
+				base += w.hourly_opentime.Format(".2006010215")
+			}
+			// A name pattern takes precedence
+			if len(w.namePattern) > 0 {
+				name, err := Format(w.namePattern, w.now())
+				if err != nil {
+					return err
+				}
+				base = filepath.Join(filepath.Dir(filename), name)
+			}
+
 			// Find the next available number
-			num := 1
 			fname := ""
-			for ; err == nil && num <= 999; num++ {
-				fname = filename + fmt.Sprintf(".%03d", num)
-				_, err = os.Lstat(fname)
+			if base != filename && !w.rotatedExists(base) {
+				fname = base
+			}
+			for num := 1; fname == "" && num <= 999; num++ {
+				if name := base + fmt.Sprintf(".%03d", num); !w.rotatedExists(name) {
+					fname = name
+				}
 			}
-			// return error if the last file checked still existed
-			if err == nil {
+			// return error if every name checked already existed
+			if fname == "" {
 				return fmt.Errorf("Rotate: Cannot find free log number to rename %s\n", filename)
 			}
 
@@ -188,71 +664,323 @@ func (w *FileLogWriter) intRotate() error {
 			if err != nil {
 				return fmt.Errorf("Rotate: %s\n", err)
 			}
+
+			// Compress it in the background so logging isn't held up
+			if w.compress {
+				w.compressWG.Add(1)
+				perm := w.perm()
+				go func() {
+					defer w.compressWG.Done()
+					if err := compressFile(fname, perm); err != nil {
+						fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+					}
+				}()
+			}
 		}
 	}
 
+	return w.open(filename)
+}
+
+// open opens filename as the log file.  The header is written to it with the
+// first record.
+func (w *FileLogWriter) open(filename string) error {
+	// Create any missing directories
+	if err := os.MkdirAll(filepath.Dir(filename), w.dirPerm); err != nil {
+		return err
+	}
+
 	// Open the log file
-	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if w.truncate {
+		flag |= os.O_TRUNC
+	}
+	fd, err := os.OpenFile(filename, flag, w.perm())
 	if err != nil {
 		return err
 	}
 	w.file = fd
+	if w.filePerm != 0 {
+		// Apply the mode exactly, regardless of the umask or an existing file
+		if err := fd.Chmod(w.filePerm); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+		}
+	}
+	if w.buf != nil {
+		w.buf.Reset(fd)
+	}
 
-	now := time.Now()
-	fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: now}))
+	w.updateSymlink()
 
-	// Set the daily open date to the current date
-	w.daily_opendate = now.Day()
+	// When appending to a file which already has records, e.g. after a
+	// restart, time-based rotation carries on from when it was last written
+	now := w.now()
+	opened, size := now, 0
+	w.existing = false
+	if fi, err := fd.Stat(); err == nil && fi.Size() > 0 {
+		opened, size = fi.ModTime(), int(fi.Size())
+		w.existing = true
+	}
 
 	// initialize rotation values
+	w.headerDue = true
 	w.maxlines_curlines = 0
-	w.maxsize_cursize = 0
+	w.maxsize_cursize = size
+	w.setOpened(opened)
 
 	return nil
 }
 
-// Delete old files from the log directory, keeping keepFiles of them
+// setOpened sets the start of the open file, from which time-based rotation
+// is reckoned
+func (w *FileLogWriter) setOpened(opened time.Time) {
+	w.opened = opened
+	w.daily_opendate = opened.Day()
+	w.hourly_opentime = opened
+	if w.cron != nil {
+		w.cron_next = w.cron.next(opened)
+	}
+}
+
+// updateSymlink points the current symlink, if any, at the open file.  The new
+// link is made under a temporary name and renamed over the old one, so the
+// symlink always exists.
+func (w *FileLogWriter) updateSymlink() {
+	if w.symlink == "" || w.file == nil {
+		return
+	}
+
+	target, err := filepath.Abs(w.file.Name())
+	if err == nil {
+		if dir, err := filepath.Abs(filepath.Dir(w.symlink)); err == nil {
+			if rel, err := filepath.Rel(dir, target); err == nil {
+				target = rel
+			}
+		}
+	}
+
+	tmp := w.symlink + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): Warning: Could not maintain symlink: %s\n", w.filename, err)
+		return
+	}
+	if err := os.Rename(tmp, w.symlink); err != nil {
+		os.Remove(tmp)
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): Warning: Could not maintain symlink: %s\n", w.filename, err)
+	}
+}
+
+// perm returns the mode to create log files with
+func (w *FileLogWriter) perm() os.FileMode {
+	if w.filePerm != 0 {
+		return w.filePerm
+	}
+	return 0660
+}
+
+// rotatedExists reports whether a rotated log file already uses the name,
+// either as is or compressed
+func (w *FileLogWriter) rotatedExists(fname string) bool {
+	if _, err := os.Lstat(fname); err == nil {
+		return true
+	}
+	if w.compress {
+		if _, err := os.Lstat(fname + ".gz"); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// missing reports whether the open file is no longer at its path, e.g.
+// because it was moved or deleted by an external logrotate.
+func (w *FileLogWriter) missing() bool {
+	if w.file == nil {
+		return false
+	}
+	cur, err := w.file.Stat()
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(w.file.Name())
+	return err != nil || !os.SameFile(fi, cur)
+}
+
+// reopen closes the log file and opens its path afresh, without rotating
+func (w *FileLogWriter) reopen() error {
+	w.writeHeader()
+	w.flush()
+	fmt.Fprint(w.file, w.formatLine(w.trailer, w.now()))
+	w.file.Close()
+
+	filename, err := Format(w.filename, w.now())
+	if err != nil {
+		return err
+	}
+	return w.open(filename)
+}
+
+// sameHour reports whether a and b fall within the same hour of the same day
+func sameHour(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd && a.Hour() == b.Hour()
+}
+
+// compressFile gzips the named file to name.gz, created with the given mode,
+// and removes the original once the compressed copy is complete.
+func compressFile(name string, perm os.FileMode) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("Compress: %s", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("Compress: %s", err)
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return fmt.Errorf("Compress: %s", err)
+	}
+
+	return os.Remove(name)
+}
+
+// The strftime directives in file names, which match words of digits or letters
+var nameDirective = regexp.MustCompile(`%[a-zA-Z]`)
+
+// namePattern returns a regular expression matching the names which name, a
+// file name with strftime directives, may expand to
+func namePattern(name string) string {
+	parts := nameDirective.Split(name, -1)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return strings.Join(parts, `\w+`)
+}
+
+// rotatedMatcher returns a regular expression matching the names of the files
+// this writer creates from file, the base of its file name: the file itself,
+// and the rotated files named from it or the name pattern, with any hourly
+// stamp, number and .gz extension which rotation adds.  Nothing else in the
+// directory matches, so that other files are never deleted.
+func (w *FileLogWriter) rotatedMatcher(file string) (*regexp.Regexp, error) {
+	names := namePattern(file)
+	if len(w.namePattern) > 0 {
+		names += `|` + namePattern(w.namePattern)
+	}
+	return regexp.Compile(`^(?:` + names + `)(?:\.\d{10})?(?:\.\d{3})?(?:\.gz)?$`)
+}
+
+// Delete old files from the log directory, keeping keepFiles of them,
+// removing any older than the maximum age and then the oldest until the files
+// are within the maximum total size
 func (w *FileLogWriter) DeleteOldFiles() {
 
 	// Do nothing if we're keeping everything
-	if w.keepNum <= 0 {
+	if w.keepNum <= 0 && w.maxAge <= 0 && w.maxTotalSize <= 0 {
 		return
 	}
 
 	// Construct a pattern to find files to delete
 	dir, file := filepath.Split(w.filename)
-	pattern := regexp.MustCompile(`%[a-zA-Z]`).ReplaceAll([]byte(file), []byte(`\d+`))
-	matcher, err := regexp.Compile(string(pattern) + `(?:\.\d{3})?`)
+	matcher, err := w.rotatedMatcher(file)
 	if err != nil {
 		return
 	}
 
 	// Find existing log files
+	if dir == "" {
+		dir = "."
+	}
 	fs, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return
 	}
+	active := ""
+	if w.file != nil {
+		active = filepath.Clean(w.file.Name())
+	}
+	cutoff := w.now().Add(-w.maxAge)
 	var old_time []int
 	old_names := make(map[string]int)
+	var files []os.FileInfo
 	for _, f := range fs {
-		if matcher.Match([]byte(f.Name())) {
+		if matcher.MatchString(f.Name()) {
+			name := filepath.Join(dir, f.Name())
+
+			// Delete anything past its maximum age, except the open file
+			if w.maxAge > 0 && name != active && f.ModTime().Before(cutoff) {
+				os.Remove(name)
+				continue
+			}
+
 			modTime := int(f.ModTime().Unix())
 			old_time = append(old_time, modTime)
-			old_names[filepath.Join(dir, f.Name())] = modTime
+			old_names[name] = modTime
+			files = append(files, f)
 		}
 	}
 
-	// Find the kth oldest timestamp
-	if len(old_time) <= w.keepNum {
-		return
+	// Find the kth oldest timestamp and delete older files
+	if w.keepNum > 0 && len(old_time) > w.keepNum {
+		sort.Ints(old_time)
+		last_time := old_time[len(old_time)-w.keepNum-1]
+		for file, time := range old_names {
+			if time <= last_time {
+				os.Remove(file)
+				delete(old_names, file)
+			}
+		}
+	}
+
+	if w.maxTotalSize > 0 {
+		w.deleteOverTotalSize(dir, files, old_names, active)
 	}
-	sort.Ints(old_time)
-	last_time := old_time[len(old_time)-w.keepNum-1]
+}
 
-	// Delete older files
-	for file, time := range old_names {
-		if time <= last_time {
-			os.Remove(file)
+// Delete the oldest of the files still in remaining, except the active file,
+// until the sizes of the files left total at most the maximum total size.
+// files are the writer's own, as found by rotatedMatcher; no other file in the
+// directory counts towards the total or is deleted.
+func (w *FileLogWriter) deleteOverTotalSize(dir string, files []os.FileInfo, remaining map[string]int, active string) {
+	var total int64
+	kept := files[:0]
+	for _, f := range files {
+		if _, ok := remaining[filepath.Join(dir, f.Name())]; ok {
+			total += f.Size()
+			kept = append(kept, f)
+		}
+	}
+
+	// Rotated files are numbered in order, so names break ties in time
+	sort.Slice(kept, func(i, j int) bool {
+		if ti, tj := kept[i].ModTime(), kept[j].ModTime(); !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return kept[i].Name() < kept[j].Name()
+	})
+	for _, f := range kept {
+		if total <= w.maxTotalSize {
+			return
+		}
+		name := filepath.Join(dir, f.Name())
+		if name == active {
+			continue
+		}
+		if os.Remove(name) == nil {
+			total -= f.Size()
 		}
 	}
 }
@@ -261,6 +989,106 @@ func (w *FileLogWriter) DeleteOldFiles() {
 // message is written.
 func (w *FileLogWriter) SetFormat(format string) *FileLogWriter {
 	w.format = format
+	noteFormat(format)
+	return w
+}
+
+// setClock replaces the clock used for time-based rotation, file names and
+// ages, headers and trailers, as a test hook.  The current file is treated as
+// opened at the clock's time, unless it already had records in it.  Must be
+// called before the first log message is written.
+func (w *FileLogWriter) setClock(now func() time.Time) *FileLogWriter {
+	w.now = now
+	if !w.existing {
+		w.setOpened(now())
+	}
+	return w
+}
+
+// Set the time layout used to render %T (chainable), as for time.Format.  An
+// empty layout restores the default.  Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetTimeFormat(layout string) *FileLogWriter {
+	w.timeFormat = layout
+	return w
+}
+
+// Set the logging format to logfmt (chainable).  Must be called before the
+// first log message is written.  See FormatLogfmt.
+func (w *FileLogWriter) SetFormatLogfmt() *FileLogWriter {
+	return w.SetFormat(FORMAT_LOGFMT)
+}
+
+// Set the size of the write buffer (chainable).  Records are then collected
+// in memory and written out when the buffer fills, at the flush interval (one
+// second unless SetFlushInterval is used), on rotation and on Close.  ERROR
+// and CRITICAL records are always written out immediately.  Must be called
+// before the first log message is written.
+func (w *FileLogWriter) SetBufferSize(size int) *FileLogWriter {
+	w.bufferSize = size
+	return w
+}
+
+// Set how often buffered records are written out (chainable).  Setting an
+// interval enables buffering even if SetBufferSize is not used, with the
+// default buffer size.  See SetBufferSize.  Must be called before the first
+// log message is written.
+func (w *FileLogWriter) SetFlushInterval(interval time.Duration) *FileLogWriter {
+	w.flushInterval = interval
+	return w
+}
+
+// Set the permissions for directories created to hold the log file
+// (chainable).  Missing parent directories are created whenever a log file is
+// opened, by default with mode 0755.  The first file is opened by the
+// constructor, so this applies to those opened later, e.g. when a time-based
+// filename moves into a new directory.
+func (w *FileLogWriter) SetDirPerm(perm os.FileMode) *FileLogWriter {
+	w.dirPerm = perm
+	return w
+}
+
+// Set the permissions of the log files (chainable).  The mode is applied to
+// the file which is already open and to every file opened after it, without
+// regard to the umask.  By default files are created with mode 0660, less the
+// umask.
+func (w *FileLogWriter) SetFilePerm(perm os.FileMode) *FileLogWriter {
+	w.filePerm = perm
+	if w.file != nil {
+		if err := w.file.Chmod(perm); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+		}
+	}
+	return w
+}
+
+// Set a symlink to keep pointing at the open log file (chainable), giving
+// tools a stable name to follow when the log filename includes the time.  The
+// link is replaced atomically each time a new file is opened.  Where symlinks
+// cannot be made, a warning is written to standard error and logging carries
+// on without one.  Must be called before the first log message is written.
+func (w *FileLogWriter) SetCurrentSymlink(path string) *FileLogWriter {
+	w.symlink = path
+	w.updateSymlink()
+	return w
+}
+
+// Set whether consecutive identical records are collapsed (chainable).  Records
+// are compared as formatted, ignoring their time.  Repeats of the last record
+// are held back and counted, then reported with a single "last message
+// repeated N times" line when a different record arrives, on rotation and on
+// Close, or once the hold time (see SetDedupHold) has passed.  Must be called
+// before the first log message is written.
+func (w *FileLogWriter) SetDedup(dedup bool) *FileLogWriter {
+	w.dedup = dedup
+	return w
+}
+
+// Set the longest time a repeat count is held back before it is written
+// (chainable).  The default is 30 seconds.  Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetDedupHold(hold time.Duration) *FileLogWriter {
+	w.dedupHold = hold
 	return w
 }
 
@@ -268,15 +1096,33 @@ func (w *FileLogWriter) SetFormat(format string) *FileLogWriter {
 // message is written.  These are formatted similar to the FormatLogRecord (e.g.
 // you can use %D and %T in your header/footer for date and time).
 func (w *FileLogWriter) SetHeadFoot(head, foot string) *FileLogWriter {
-	w.header, w.trailer = head, foot
-	if w.maxlines_curlines == 0 {
-		fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: time.Now()}))
-	}
+	return w.SetHeader(head).SetTrailer(foot)
+}
+
+// SetHeader sets a line written at the top of every file (chainable), both the
+// first and each one opened by rotation, e.g. a schema line for log parsers.
+// It is formatted like SetHeadFoot and does not count towards SetRotateLines.
+// Must be called before the first log message is written.
+func (w *FileLogWriter) SetHeader(head string) *FileLogWriter {
+	w.header = head
 	return w
 }
 
-// Set rotate at linecount (chainable). Must be called before the first log
+// SetTrailer sets a line written at the end of every file (chainable), when it
+// is rotated or the writer is closed.  It is formatted like SetHeadFoot and
+// does not count towards SetRotateLines.  Must be called before the first log
 // message is written.
+func (w *FileLogWriter) SetTrailer(foot string) *FileLogWriter {
+	w.trailer = foot
+	return w
+}
+
+// Set rotate at linecount (chainable). Must be called before the first log
+// message is written.  Each file holds at most maxlines lines of records; the
+// header and trailer are not counted.  The record which would take a file past
+// maxlines is written to a new file, the rotation happening just before it is
+// written, so a file holds exactly maxlines lines when every record is one
+// line.  A single record longer than maxlines is never split.
 func (w *FileLogWriter) SetRotateLines(maxlines int) *FileLogWriter {
 	//fmt.Fprintf(os.Stderr, "FileLogWriter.SetRotateLines: %v\n", maxlines)
 	w.maxlines = maxlines
@@ -299,10 +1145,127 @@ func (w *FileLogWriter) SetRotateDaily(daily bool) *FileLogWriter {
 	return w
 }
 
+// Set rotate hourly (chainable). Must be called before the first log message
+// is written.  Rotated files are named with the hour they cover, e.g.
+// app.log.2006010215, with a numeric suffix added if that name is taken.
+func (w *FileLogWriter) SetRotateHourly(hourly bool) *FileLogWriter {
+	w.hourly = hourly
+	return w
+}
+
+// Set rotation on a cron schedule (chainable), e.g. "0 0,12" for midnight and
+// noon.  The spec has the five fields "minute hour day-of-month month
+// day-of-week", or just the first two; each is *, a number, a range such as
+// 1-5 or a list of these, optionally with a step such as */15.  The file is
+// rotated when a log message is written at or after the next time on the
+// schedule, in addition to any other rotation settings.  An empty spec turns
+// the schedule off; an invalid one is reported on standard error and ignored.
+// Must be called before the first log message is written.
+func (w *FileLogWriter) SetRotateCron(spec string) *FileLogWriter {
+	if len(spec) == 0 {
+		w.cron = nil
+		return w
+	}
+	cron, err := parseCron(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+		return w
+	}
+	w.cron = cron
+	w.cron_next = cron.next(w.opened)
+	return w
+}
+
+// Set the name given to old logs when they are rotated (chainable), instead of
+// numbering them (.001, .002, etc).  The pattern may contain the strftime
+// directives understood by Format, which are filled in with the time of the
+// rotation, e.g. "app-%Y%m%d-%H%M%S.log"; it is relative to the directory of
+// the log file.  If the name is taken, a numeric suffix is appended.  An empty
+// pattern restores the numbering.  Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetRotateNamePattern(pattern string) *FileLogWriter {
+	w.namePattern = pattern
+	return w
+}
+
+// SetReopenIfMissing makes the writer check before each log message whether
+// its file is still at its path, and if it has been moved or deleted, e.g. by
+// an external logrotate, reopen the path rather than keep writing to the old
+// file (chainable).  The check costs a couple of system calls per message.
+// Must be called before the first log message is written.
+func (w *FileLogWriter) SetReopenIfMissing(reopen bool) *FileLogWriter {
+	w.reopenIfMissing = reopen
+	return w
+}
+
+// Set what to do when writing a record fails, e.g. because the disk is full
+// (chainable).  The function is called with each error and decides whether to
+// Retry the record, Drop it, or Disable the writer, after which every record
+// is dropped without being written.  Each failed attempt counts as an error in
+// Stats, and each record dropped while disabled as dropped.  Without a
+// policy, the first error is reported on standard error and the writer stops.
+// Must be called before the first log message is written.
+func (w *FileLogWriter) SetOnError(onError func(error) Action) *FileLogWriter {
+	w.onError = onError
+	return w
+}
+
+// SetLineSeparator changes what terminates each record, and the header and
+// trailer, in place of "\n" (chainable), e.g. "\r\n" for readers which expect
+// Windows line endings.  Newlines within a message are left as they are, and
+// are still what SetRotateLines counts.  Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetLineSeparator(sep string) *FileLogWriter {
+	w.lineSep = sep
+	return w
+}
+
+// formatLine formats the header or trailer, terminated by the line separator
+func (w *FileLogWriter) formatLine(format string, now time.Time) string {
+	if w.loc != nil {
+		now = now.In(w.loc)
+	}
+	return withLineSeparator(formatLogRecord(format, &LogRecord{Created: now}, w.timeFormat), w.lineSep)
+}
+
+// SetUTC makes the writer render times in UTC, or in local time if utc is
+// false, whichever its logger's Logger.SetUTC gives the records (chainable).
+// This includes the times in the header and trailer, but not the times which
+// rotation and file names are based on.  Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetUTC(utc bool) *FileLogWriter {
+	w.loc = utcLocation(utc)
+	return w
+}
+
+// SetAppend changes whether the file is appended to or truncated when it is
+// opened (chainable).  Files are appended to by default.  Truncating takes
+// effect straight away, emptying the file opened by NewFileLogWriter, and
+// again whenever the same path is reopened without rotation.  With rotation
+// enabled, an existing file is moved aside when the writer is created, so
+// nothing is lost either way; to keep appending to it across restarts instead,
+// create the writer without rotation and enable it with SetRotate.  Must be
+// called before the first log message is written.
+func (w *FileLogWriter) SetAppend(append bool) *FileLogWriter {
+	w.truncate = !append
+	if w.truncate && w.file != nil && w.maxlines_curlines == 0 {
+		if err := w.file.Truncate(0); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+			return w
+		}
+		now := w.now()
+		w.headerDue = true
+		w.maxsize_cursize = 0
+		w.existing = false
+		w.setOpened(now)
+	}
+	return w
+}
+
 // SetRotate changes whether or not the old logs are kept. (chainable) Must be
 // called before the first log message is written.  If rotate is false, the
-// files are overwritten; otherwise, they are rotated to another file before the
-// new log is opened.
+// files are appended to, or overwritten with SetAppend(false); otherwise, they
+// are rotated to another file before the new log is opened.
 func (w *FileLogWriter) SetRotate(rotate bool) *FileLogWriter {
 	//fmt.Fprintf(os.Stderr, "FileLogWriter.SetRotate: %v\n", rotate)
 	w.rotate = rotate
@@ -320,13 +1283,123 @@ func (w *FileLogWriter) SetKeepNum(keepNum int) *FileLogWriter {
 	return w
 }
 
-// NewXMLLogWriter is a utility method for creating a FileLogWriter set up to
-// output XML record log messages instead of line-based ones.
-func NewXMLLogWriter(fname string, rotate bool) *FileLogWriter {
-	return NewFileLogWriter(fname, rotate).SetFormat(
-		`	<record level="%L">
-		<timestamp>%D %T</timestamp>
+// SetMaxAge changes whether older log files are deleted based on their age.
+// Ignored unless SetRotate is true. If this is 0, nothing will be deleted. If
+// it's >0, rotated log files last modified longer ago than this are deleted.
+// This applies alongside SetKeepNum: a file is deleted if either limit is
+// exceeded. Deletion occurs when the log file is opened or rotated.
+func (w *FileLogWriter) SetMaxAge(maxAge time.Duration) *FileLogWriter {
+	w.maxAge = maxAge
+	w.DeleteOldFiles()
+	return w
+}
+
+// SetMaxTotalSize changes whether older log files are deleted based on the
+// space the logs take up (chainable).  Ignored unless SetRotate is true.  If
+// this is 0, nothing will be deleted.  If it's >0, then after SetKeepNum and
+// SetMaxAge are applied, the oldest rotated log files are deleted until the
+// sizes of the current and rotated files total at most this many bytes.
+// Deletion occurs when the log file is opened or rotated.
+func (w *FileLogWriter) SetMaxTotalSize(bytes int) *FileLogWriter {
+	w.maxTotalSize = int64(bytes)
+	w.DeleteOldFiles()
+	return w
+}
+
+// SetCompressRotated changes whether rotated log files are gzipped
+// (chainable).  Ignored unless SetRotate is true.  Each rotated file is
+// compressed to <name>.gz in the background and the original is removed.
+func (w *FileLogWriter) SetCompressRotated(compress bool) *FileLogWriter {
+	w.compress = compress
+	return w
+}
+
+// NewJSONLogWriter is a utility method for creating a FileLogWriter set up to
+// output newline-delimited JSON, one object per record (see FormatJSON),
+// instead of line-based text.
+func NewJSONLogWriter(fname string, rotate bool) *FileLogWriter {
+	w := NewFileLogWriter(fname, rotate)
+	if w == nil {
+		return nil
+	}
+	return w.SetFormat(FORMAT_JSON)
+}
+
+// Timestamp formats for SetXMLTimestamp
+const (
+	XML_TIMESTAMP_DEFAULT  = "default" // 2006/01/02 15:04:05 MST
+	XML_TIMESTAMP_RFC3339  = "rfc3339" // 2006-01-02T15:04:05.999999999Z07:00
+	XML_TIMESTAMP_EPOCH_MS = "epochms" // Milliseconds since the Unix epoch
+)
+
+// xmlTimestamps maps the timestamp formats to the directives rendering them
+var xmlTimestamps = map[string]string{
+	XML_TIMESTAMP_DEFAULT:  "%D %T",
+	XML_TIMESTAMP_RFC3339:  "%I",
+	XML_TIMESTAMP_EPOCH_MS: "%E",
+}
+
+// xmlRecordFormat returns the format of an XML record whose timestamp is
+// rendered by the given directives
+func xmlRecordFormat(timestamp string) string {
+	return `	<record level="%L">
+		<timestamp>` + timestamp + `</timestamp>
 		<source>%S</source>
 		<message>%M</message>
-	</record>`).SetHeadFoot("<log created=\"%D %T\">", "</log>")
+	</record>`
+}
+
+// NewXMLLogWriter is a utility method for creating a FileLogWriter set up to
+// output XML record log messages instead of line-based ones.  The source,
+// message and fields of each record are escaped, and characters which XML does
+// not allow are replaced with U+FFFD.
+func NewXMLLogWriter(fname string, rotate bool) *FileLogWriter {
+	w := NewFileLogWriter(fname, rotate)
+	if w == nil {
+		return nil
+	}
+	w.xml = true
+	return w.SetFormat(
+		xmlRecordFormat(xmlTimestamps[XML_TIMESTAMP_DEFAULT])).SetHeadFoot("<log created=\"%D %T\">", "</log>")
+}
+
+// xmlEscape escapes s for use as XML character data or an attribute value
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// xmlEscapeRecord returns a copy of rec with its source, message and fields
+// escaped for XML.
+func xmlEscapeRecord(rec *LogRecord) *LogRecord {
+	esc := &LogRecord{
+		Level:   rec.Level,
+		Created: rec.Created,
+		Source:  xmlEscape(rec.Source),
+		Message: xmlEscape(rec.Message),
+		file:    xmlEscape(rec.file),
+		goid:    rec.goid,
+	}
+	if len(rec.Fields) > 0 {
+		esc.Fields = make(map[string]interface{}, len(rec.Fields))
+		for k, v := range rec.Fields {
+			esc.Fields[xmlEscape(k)] = xmlEscape(fmt.Sprint(v))
+		}
+	}
+	return esc
+}
+
+// SetXMLTimestamp changes the format of the timestamp in each record written
+// by a writer from NewXMLLogWriter (chainable): one of XML_TIMESTAMP_DEFAULT,
+// XML_TIMESTAMP_RFC3339 or XML_TIMESTAMP_EPOCH_MS.  The created attribute of
+// the log element is not affected.  An unknown format is reported and ignored.
+// Must be called before the first log message is written.
+func (w *FileLogWriter) SetXMLTimestamp(format string) *FileLogWriter {
+	timestamp, ok := xmlTimestamps[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): unknown XML timestamp format %q\n", w.filename, format)
+		return w
+	}
+	return w.SetFormat(xmlRecordFormat(timestamp))
 }
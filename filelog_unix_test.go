@@ -0,0 +1,48 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+
+package log4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileLogWriterRotateOnSignal(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "signal.log")
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer w.Close()
+	stop := w.RotateOnSignal(syscall.SIGHUP)
+	defer stop()
+
+	w.LogWrite(newLogRecord(INFO, "source", "before"))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("kill: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if contents, _ := ioutil.ReadFile(logfile + ".001"); string(contents) == "before\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for SIGHUP to rotate the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.LogWrite(newLogRecord(INFO, "source", "after"))
+	w.Flush()
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "after\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+// SetHook makes the logger call each of the given functions with every record
+// it dispatches, i.e. every record at a level which at least one filter logs,
+// before the record is passed to any writer.  The hooks are called
+// synchronously by the logging goroutine, so they must be quick, e.g. to count
+// records by level for metrics.  Each hook is given its own copy of the
+// record, but the record's Fields are shared and must not be modified.
+// Calling SetHook replaces any hooks set before; calling it with no hooks
+// removes them.  Close also removes them.
+func (log Logger) SetHook(hook ...func(r *LogRecord)) {
+	hooks := append([]func(*LogRecord){}, hook...)
+	log.setOptions(func(opts *loggerOptions) {
+		opts.hooks = hooks
+	})
+}
+
+// runHooks calls the logger's hooks, if it has any, with copies of rec
+func (opts *loggerOptions) runHooks(rec *LogRecord) {
+	for _, hook := range opts.hooks {
+		cp := *rec
+		cp.pooled = false
+		hook(&cp)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// HTTPRetries is how many times an HTTPLogWriter retries a batch which
+	// the server failed with a 5xx status before giving up on it.
+	HTTPRetries = 3
+
+	// Delay before the first retry of a batch, doubled for each one after
+	httpRetryBackoff = 500 * time.Millisecond
+)
+
+// This log writer POSTs batches of records to an HTTP endpoint
+type HTTPLogWriter struct {
+	rec  chan *LogRecord
+	fl   chan chan error
+	done chan bool
+
+	closeOnce sync.Once
+
+	url    string
+	header http.Header
+	client *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+
+	// Records accepted by the server, and records lost to errors
+	written, errored int64
+
+	// Set while the last batch failed
+	failing int32
+}
+
+// NewHTTPLogWriter creates a new LogWriter which POSTs records to the url as a
+// JSON array, in batches of up to batchSize records.  A batch is sent once it
+// is full or flushInterval after its first record, whichever comes first, and
+// on Close.  A batch which fails with a 5xx status is retried up to HTTPRetries
+// times; any other failure drops it with an error on standard error.
+func NewHTTPLogWriter(url string, batchSize int, flushInterval time.Duration) *HTTPLogWriter {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	w := &HTTPLogWriter{
+		rec:           make(chan *LogRecord, LogBufferLength),
+		fl:            make(chan chan error),
+		done:          make(chan bool),
+		url:           url,
+		header:        make(http.Header),
+		client:        &http.Client{Timeout: 30 * time.Second},
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+	w.header.Set("Content-Type", "application/json")
+
+	go w.run()
+	return w
+}
+
+func (w *HTTPLogWriter) run() {
+	defer close(w.done)
+
+	var batch []*LogRecord
+	var timer *time.Timer
+	var flush <-chan time.Time
+
+	send := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		err := w.post(batch)
+		if err != nil {
+			atomic.AddInt64(&w.errored, int64(len(batch)))
+			atomic.StoreInt32(&w.failing, 1)
+			fmt.Fprintf(os.Stderr, "HTTPLogWriter(%q): %s\n", w.url, err)
+		} else {
+			atomic.AddInt64(&w.written, int64(len(batch)))
+			atomic.StoreInt32(&w.failing, 0)
+		}
+		batch = nil
+		return err
+	}
+
+	for {
+		select {
+		case rec, ok := <-w.rec:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= w.batchSize {
+				send()
+			} else if timer == nil {
+				timer = time.NewTimer(w.flushInterval)
+				flush = timer.C
+			}
+		case <-flush:
+			timer, flush = nil, nil
+			send()
+		case flushed := <-w.fl:
+			// Send everything queued before the flush was requested
+		drain:
+			for n := len(w.rec); n > 0; n-- {
+				select {
+				case rec, ok := <-w.rec:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, rec)
+				default:
+				}
+			}
+			flushed <- send()
+		}
+	}
+}
+
+// post sends a batch, retrying it if the server fails with a 5xx status.
+// Fields which cannot be encoded as JSON are sent as strings.
+func (w *HTTPLogWriter) post(batch []*LogRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		body, err = json.Marshal(jsonRecords(batch...))
+	}
+	if err != nil {
+		return err
+	}
+
+	backoff := httpRetryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = w.header.Clone()
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode < 500 || attempt >= HTTPRetries:
+			return fmt.Errorf("dropped %d records: %s", len(batch), resp.Status)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// This is the HTTPLogWriter's output method
+func (w *HTTPLogWriter) LogWrite(rec *LogRecord) {
+	w.rec <- rec
+}
+
+// Flush sends the records logged before the call without waiting for the batch
+// to fill, returning once the server has accepted them.
+func (w *HTTPLogWriter) Flush() error {
+	flushed := make(chan error, 1)
+	select {
+	case w.fl <- flushed:
+		return <-flushed
+	case <-w.done:
+		return nil
+	}
+}
+
+// Stats returns the number of records accepted by the server and the number in
+// batches which failed.
+func (w *HTTPLogWriter) Stats() WriterStats {
+	return WriterStats{
+		Written: atomic.LoadInt64(&w.written),
+		Errors:  atomic.LoadInt64(&w.errored),
+	}
+}
+
+// Healthy reports whether the last batch was accepted by the server.  A writer
+// which has yet to send anything is healthy.
+func (w *HTTPLogWriter) Healthy() bool {
+	return atomic.LoadInt32(&w.failing) == 0
+}
+
+// Close stops the writer, waiting for the final batch to be sent.  Calling it
+// again does nothing.
+func (w *HTTPLogWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.rec)
+		<-w.done
+	})
+}
+
+// Set a header to send with each request (chainable), e.g. for an
+// authorization token.  Must be called before the first log message is
+// written.
+func (w *HTTPLogWriter) SetHeader(name, value string) *HTTPLogWriter {
+	w.header.Set(name, value)
+	return w
+}
+
+func propsToHTTPLogWriter(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+	url := ""
+	batchSize := 100
+	flushInterval := time.Second
+	header := make(map[string]string)
+
+	// Parse properties
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch {
+		case name == "url":
+			url = expandEnv(filename, "http", value)
+		case name == "batchsize":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Property \"%s\" for http filter has invalid value in %s: %s\n", "batchsize", filename, value)
+			}
+			batchSize = n
+		case name == "flushinterval":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse property \"%s\" for http filter in %s: %s\n", "flushinterval", filename, err)
+			}
+			flushInterval = d
+		case strings.HasPrefix(name, "header."):
+			header[strings.TrimPrefix(name, "header.")] = expandEnv(filename, "http", value)
+		default:
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for http filter in %s\n", name, filename)
+		}
+	}
+
+	// Check properties
+	if len(url) == 0 {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Required property \"%s\" for http filter missing in %s\n", "url", filename)
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !enabled {
+		return nil, nil
+	}
+
+	hlw := NewHTTPLogWriter(url, batchSize, flushInterval)
+	for name, value := range header {
+		hlw.SetHeader(name, value)
+	}
+	return hlw, nil
+}
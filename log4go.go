@@ -28,6 +28,13 @@
 //   output, but the FileLogWriter does.
 // - The utility functions (Info, Debug, Warn, etc) derive their source from the
 //   calling function, and this incurs extra overhead.
+// - Building with the log4go_release tag (go build -tags log4go_release)
+//   compiles out the FINEST, FINE and DEBUG levels: Finest, Fine and Debug
+//   become empty functions which the compiler can remove, and records below
+//   TRACE are dropped however they are logged.
+// - YAML configuration files need gopkg.in/yaml.v2, so they are only
+//   supported when building with the log4go_yaml tag (go build -tags
+//   log4go_yaml); the package otherwise uses the standard library alone.
 //
 // Changes from 2.0:
 // - The external interface has remained mostly stable, but a lot of the
@@ -36,6 +43,19 @@
 //   particular, Logger is now a map and ConsoleLogWriter is now a channel
 //   behind-the-scenes, and the LogWrite method no longer has return values.
 //
+// Changes from 3.0.2:
+// - ConsoleLogWriter is now a struct rather than a channel, so that it can be
+//   configured (split streams, format, colors and so on), and
+//   NewConsoleLogWriter returns a *ConsoleLogWriter.  Code which passes the
+//   writer straight to AddFilter is unaffected, but code which made one with
+//   make(ConsoleLogWriter) must call NewConsoleLogWriter instead, and code
+//   which stored a ConsoleLogWriter must store a *ConsoleLogWriter.
+// - SocketLogWriter is likewise a struct, and NewSocketLogWriter returns a
+//   *SocketLogWriter.  Code which made one with make(SocketLogWriter) must
+//   call NewSocketLogWriter instead, and code which stored a SocketLogWriter
+//   must store a *SocketLogWriter.  NewSocketLogWriter no longer returns nil
+//   when it cannot connect, but a writer which keeps trying to.
+//
 // Future work: (please let me know if you think I should work on any of these particularly)
 // - Log file rotation
 // - Logging configuration files ala log4j
@@ -46,11 +66,21 @@
 package log4go
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -76,18 +106,36 @@ const (
 	WARNING
 	ERROR
 	CRITICAL
+
+	// OFF is above every log level; a filter set to OFF writes nothing.
+	OFF
 )
 
 // Logging level strings
 var (
-	levelStrings = [...]string{"FNST", "FINE", "DEBG", "TRAC", "INFO", "WARN", "EROR", "CRIT"}
+	levelStrings = [...]string{"FNST", "FINE", "DEBG", "TRAC", "INFO", "WARN", "EROR", "CRIT", "OFF"}
+	levelNames   = [...]string{"FINEST", "FINE", "DEBUG", "TRACE", "INFO", "WARNING", "ERROR", "CRITICAL", "OFF"}
+	levelLetters = [...]string{"N", "F", "D", "T", "I", "W", "E", "C", "O"}
 )
 
+// String returns the canonical name of the level (e.g. "WARNING"), as used in
+// configuration files.  The log formats use the four letter abbreviations.
 func (l Level) String() string {
-	if l < 0 || int(l) > len(levelStrings) {
+	if l < 0 || int(l) >= len(levelNames) {
 		return "UNKNOWN"
 	}
-	return levelStrings[int(l)]
+	return levelNames[int(l)]
+}
+
+// LevelFromString returns the level with the given canonical name (e.g.
+// "WARNING"), and whether there is such a level.  Names are case sensitive.
+func LevelFromString(s string) (Level, bool) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), true
+		}
+	}
+	return 0, false
 }
 
 /****** Variables ******/
@@ -95,6 +143,11 @@ var (
 	// LogBufferLength specifies how many log messages a particular log4go
 	// logger can buffer at a time before writing them.
 	LogBufferLength = 32
+
+	// filtersMu protects the filter maps of all Loggers, so that filters may
+	// be swapped out (e.g. on a configuration reload) while logging.  It is
+	// not held while records are written.
+	filtersMu sync.RWMutex
 )
 
 /****** LogRecord ******/
@@ -105,6 +158,20 @@ type LogRecord struct {
 	Created time.Time // The time at which the log message was created (nanoseconds)
 	Source  string    // The message source
 	Message string    // The log message
+
+	Fields map[string]interface{} `json:",omitempty"` // Structured key/value fields
+
+	file  string // The file:line of the message source, if known
+	goid  int64  // The ID of the goroutine which logged the message, if known
+	depth int    // The frames from dispatch to the message source, if known
+
+	// For records from the pool, the holders and whether a writer may keep it
+	pooled bool
+	refs   int32
+	kept   bool
+
+	// Logged with LogChecked, so writers are flushed to learn of errors
+	checked bool
 }
 
 /****** LogWriter ******/
@@ -119,15 +186,77 @@ type LogWriter interface {
 	Close()
 }
 
+// A Flusher is a LogWriter which holds records in memory before writing them
+// out.  Flush writes out everything logged before the call, returning once it
+// is done.
+type Flusher interface {
+	Flush() error
+}
+
 /****** Logger ******/
 
 // A Filter represents the log level below which no log records are written to
 // the associated LogWriter.
+//
+// Once a Filter has been added to a Logger which is in use, its level should
+// only be changed through Logger.SetLevel, which synchronizes with the
+// dispatch of log records.
 type Filter struct {
 	Level Level
 	LogWriter
 }
 
+// filterState holds the settings and counters of a Filter beyond its exported
+// fields.  It is kept in a table beside the Filter, rather than in it, so that
+// a Filter can still be built with an unkeyed literal such as &Filter{lvl, w}.
+type filterState struct {
+	mu      sync.RWMutex // protects Level, limiter and the patterns while logging
+	limiter *rateLimiter
+
+	// Write only messages matching include, if set, and not exclude
+	include, exclude *regexp.Regexp
+
+	// Pass only every sampleRate-th record, counting them in sampled
+	sampleRate int64
+	sampled    uint64
+
+	// Frames to skip above the logger's source, see SetCallerSkip
+	skip int32
+
+	// Longest message written, see SetMaxMessageLen
+	maxLen int64
+
+	// Set once the writer has panicked, after which it is given no records
+	broken int32
+
+	// Held for reading while a record is written, and for writing while the
+	// filter is closed, so that Close waits for records being written
+	writing sync.RWMutex
+	closed  bool
+}
+
+// The state of each Filter in use, built when first needed and dropped when
+// the filter is closed
+var filterStates sync.Map // map[*Filter]*filterState
+
+// state returns the filter's state, creating it if need be
+func (filt *Filter) state() *filterState {
+	if st, ok := filterStates.Load(filt); ok {
+		return st.(*filterState)
+	}
+	st, _ := filterStates.LoadOrStore(filt, new(filterState))
+	return st.(*filterState)
+}
+
+// level returns the filter's current level, synchronized with SetLevel
+func (filt *Filter) level() Level {
+	st := filt.state()
+	st.mu.RLock()
+	lvl := filt.Level
+	st.mu.RUnlock()
+	return lvl
+}
+
 // A Logger represents a collection of Filters through which log messages are
 // written.
 type Logger map[string]*Filter
@@ -147,7 +276,7 @@ func NewLogger() Logger {
 func NewConsoleLogger(lvl Level) Logger {
 	os.Stderr.WriteString("warning: use of deprecated NewConsoleLogger\n")
 	return Logger{
-		"stdout": &Filter{lvl, NewConsoleLogWriter()},
+		"stdout": &Filter{Level: lvl, LogWriter: NewConsoleLogWriter()},
 	}
 }
 
@@ -155,51 +284,478 @@ func NewConsoleLogger(lvl Level) Logger {
 // or above lvl to standard output.
 func NewDefaultLogger(lvl Level) Logger {
 	return Logger{
-		"stdout": &Filter{lvl, NewConsoleLogWriter()},
+		"stdout": &Filter{Level: lvl, LogWriter: NewConsoleLogWriter()},
 	}
 }
 
 // Closes all log writers in preparation for exiting the program or a
 // reconfiguration of logging.  Calling this is not really imperative, unless
 // you want to guarantee that all log messages are written.  Close removes
-// all filters (and thus all LogWriters) from the logger.
+// all filters (and thus all LogWriters) from the logger, and restores its
+// default settings (hooks, redactors and the like).
 func (log Logger) Close() {
-	// Close all open loggers
+	log.closeFilters()
+	log.resetOptions()
+}
+
+// closeFilters removes and closes the logger's filters, keeping its other
+// settings.  Loading a configuration replaces the filters this way, so that
+// settings such as redactors made beforehand still apply.
+func (log Logger) closeFilters() {
+	filtersMu.Lock()
+	filts := make([]*Filter, 0, len(log))
 	for name, filt := range log {
+		filts = append(filts, filt)
+		delete(log, name)
+	}
+	filtersMu.Unlock()
+
+	// Close all open loggers
+	for _, filt := range filts {
 		filt.Close()
+	}
+}
+
+// CloseContext closes the writers of all filters concurrently, waiting until
+// they finish or ctx is done.  Writers which panic while closing, and writers
+// still closing when ctx is done, are reported in the returned error, which
+// joins one error per writer as errors.Join does.  A writer is closed once it
+// has written any record it was given, so a writer stuck writing one times out
+// too.  Writers which time out are left to finish in the background.
+func (log Logger) CloseContext(ctx context.Context) error {
+	filtersMu.Lock()
+	names := make([]string, 0, len(log))
+	filts := make(map[string]*Filter, len(log))
+	for name, filt := range log {
+		names = append(names, name)
+		filts[name] = filt
+		delete(log, name)
+	}
+	filtersMu.Unlock()
+	log.resetOptions()
+	sort.Strings(names)
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(names))
+	for _, name := range names {
+		go func(name string, filt *Filter) {
+			results <- result{name, safely(filt.Close)}
+		}(name, filts[name])
+	}
+
+	errs := make(map[string]error, len(names))
+	pending := len(names)
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			delete(filts, r.name)
+			if r.err != nil {
+				errs[r.name] = fmt.Errorf("filter %q: %s", r.name, r.err)
+			}
+		case <-ctx.Done():
+			for name := range filts {
+				errs[name] = fmt.Errorf("filter %q: close timed out: %w", name, ctx.Err())
+			}
+			pending = 0
+		}
+	}
+
+	joined := make([]error, 0, len(errs))
+	for _, name := range names {
+		if err, ok := errs[name]; ok {
+			joined = append(joined, err)
+		}
+	}
+	return errors.Join(joined...)
+}
+
+// CloseOnSignal closes the logger, writing out any buffered records, when the
+// process receives one of sigs, or os.Interrupt or syscall.SIGTERM if none are
+// given, so that records are not lost by programs stopped without calling
+// Close.  The signal's handling is then restored, unless other channels were
+// registered for it with signal.Notify, and the signal is raised again, so the
+// process exits as it would have without the handler; where the signal cannot
+// be raised, the process exits with status 1.  Call the returned function to
+// stop listening.
+func (log Logger) CloseOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	quit := make(chan bool)
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+
+	go func() {
+		select {
+		case sig := <-ch:
+			stop()
+			log.Close()
+			raise(sig)
+		case <-quit:
+		}
+	}()
+
+	return stop
+}
+
+// raise sends sig to the process, exiting if it cannot be sent
+func raise(sig os.Signal) {
+	if p, err := os.FindProcess(os.Getpid()); err == nil && p.Signal(sig) == nil {
+		return
+	}
+	os.Exit(1)
+}
+
+// Flush flushes the writer of every filter which is a Flusher, returning once
+// the records logged before the call have been written out.  Writers which are
+// not Flushers are skipped.  The first error encountered is returned, but every
+// writer is flushed.
+func (log Logger) Flush() error {
+	filtersMu.RLock()
+	flushers := make([]Flusher, 0, len(log))
+	for _, filt := range log {
+		if f, ok := filt.LogWriter.(Flusher); ok {
+			flushers = append(flushers, f)
+		}
+	}
+	filtersMu.RUnlock()
+
+	var first error
+	for _, f := range flushers {
+		if err := f.Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// replaceFilters atomically replaces the filters of the logger with those of
+// from, then closes the writers of the filters which were replaced.
+func (log Logger) replaceFilters(from Logger) {
+	filtersMu.Lock()
+	old := make([]*Filter, 0, len(log))
+	for name, filt := range log {
+		old = append(old, filt)
 		delete(log, name)
 	}
+	for name, filt := range from {
+		log[name] = filt
+	}
+	filtersMu.Unlock()
+
+	for _, filt := range old {
+		filt.Close()
+	}
 }
 
 // Add a new LogWriter to the Logger which will only log messages at lvl or
 // higher.  This function should not be called from multiple goroutines.
-// Returns the logger for chaining.
+// Returns the logger for chaining.  A filter already added with the same name
+// is replaced, and its writer closed unless it is the one being added.
 func (log Logger) AddFilter(name string, lvl Level, writer LogWriter) Logger {
-	log[name] = &Filter{lvl, writer}
+	filtersMu.Lock()
+	old := log[name]
+	log[name] = &Filter{Level: lvl, LogWriter: writer}
+	filtersMu.Unlock()
+
+	if old != nil && !sameWriter(old.LogWriter, writer) {
+		old.Close()
+	}
 	return log
 }
 
+// RemoveFilter removes the filter with the given name from the Logger and
+// closes its writer.  It does nothing if there is no such filter.
+func (log Logger) RemoveFilter(name string) {
+	filtersMu.Lock()
+	old := log[name]
+	delete(log, name)
+	filtersMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// sameWriter reports whether a and b are the same writer, without panicking
+// on writers which cannot be compared
+func sameWriter(a, b LogWriter) bool {
+	ta := reflect.TypeOf(a)
+	return ta == reflect.TypeOf(b) && ta != nil && ta.Comparable() && a == b
+}
+
+// SetLevel changes the level of the filter with the given tag.  It is safe to
+// call while other goroutines are logging.  Returns an error if no filter has
+// the given tag.
+//
+// A tag containing any of the wildcards *, ? or [ is a pattern, as for
+// path.Match, and changes the level of every filter whose tag matches it: e.g.
+// "http.*" matches "http.server" and "http.server.tls" but not "https".  An
+// error is returned if the pattern is malformed or matches no filters.
+func (log Logger) SetLevel(tag string, lvl Level) error {
+	var filts []*Filter
+	filtersMu.RLock()
+	if strings.ContainsAny(tag, "*?[") {
+		for name, filt := range log {
+			ok, err := path.Match(tag, name)
+			if err != nil {
+				filtersMu.RUnlock()
+				return fmt.Errorf("SetLevel: bad pattern %q: %s", tag, err)
+			}
+			if ok {
+				filts = append(filts, filt)
+			}
+		}
+	} else if filt, ok := log[tag]; ok {
+		filts = append(filts, filt)
+	}
+	filtersMu.RUnlock()
+	if len(filts) == 0 {
+		return fmt.Errorf("SetLevel: unknown filter %q", tag)
+	}
+
+	for _, filt := range filts {
+		st := filt.state()
+		st.mu.Lock()
+		filt.Level = lvl
+		st.mu.Unlock()
+	}
+	return nil
+}
+
+// GetLevel returns the level of the filter with the given tag, and whether
+// such a filter exists.
+func (log Logger) GetLevel(tag string) (Level, bool) {
+	filtersMu.RLock()
+	filt, ok := log[tag]
+	filtersMu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return filt.level(), true
+}
+
+// A FilterInfo describes one of the filters of a Logger
+type FilterInfo struct {
+	Tag    string
+	Level  Level
+	Writer string // The type of writer, e.g. "file" or "console"
+}
+
+// Filters returns a description of each of the logger's filters, sorted by
+// tag.  The writers of this package are described by their types in
+// configuration files, e.g. "file", "xml" or "socket"; other writers by their
+// Go types.  It is safe to call while other goroutines are logging.
+func (log Logger) Filters() []FilterInfo {
+	filtersMu.RLock()
+	infos := make([]FilterInfo, 0, len(log))
+	for tag, filt := range log {
+		infos = append(infos, FilterInfo{
+			Tag:    tag,
+			Level:  filt.level(),
+			Writer: writerType(filt.LogWriter),
+		})
+	}
+	filtersMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tag < infos[j].Tag })
+	return infos
+}
+
+// writerType returns a readable name for the type of a writer
+func writerType(w LogWriter) string {
+	if fw, ok := w.(*FileLogWriter); ok {
+		switch {
+		case fw.xml:
+			return "xml"
+		case fw.format == FORMAT_JSON:
+			return "json"
+		}
+	}
+
+	// The writers of this package are named for their types, e.g.
+	// SocketLogWriter is "socket"
+	t := reflect.TypeOf(w)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && t.PkgPath() == reflect.TypeOf(Filter{}).PkgPath() && strings.HasSuffix(t.Name(), "LogWriter") {
+		return strings.ToLower(strings.TrimSuffix(t.Name(), "LogWriter"))
+	}
+	return fmt.Sprintf("%T", w)
+}
+
 /******* Logging *******/
-// Send a formatted log message internally
-func (log Logger) intLogf(lvl Level, format string, args ...interface{}) {
-	skip := true
+// Determine if any logging will be done at lvl
+func (log Logger) skip(lvl Level) bool {
+	if lvl >= OFF || lvl < minCompiledLevel {
+		return true
+	}
 
-	// Determine if any logging will be done
+	opts := log.options()
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
 	for _, filt := range log {
-		if lvl >= filt.Level {
-			skip = false
+		if lvl >= opts.filterLevel(filt.level()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Enabled reports whether any filter would write a record at the given level.
+// Use it to avoid building expensive log messages which would be discarded:
+//
+//	if log.Enabled(DEBUG) {
+//		log.Debug("state: %s", dumpState())
+//	}
+func (log Logger) Enabled(lvl Level) bool {
+	return !log.skip(lvl)
+}
+
+// Determine the source of a log message, skip frames above the caller of
+// callerSource.  Returns the function and file positions of the call.
+func callerSource(skip int) (src, file string) {
+	pc, file, lineno, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "", ""
+	}
+	return fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), lineno), fmt.Sprintf("%s:%d", file, lineno)
+}
+
+// Determine the ID of the calling goroutine by parsing the header of its
+// stack trace, "goroutine 123 [running]:".  Go deliberately offers no better
+// way, so this costs about a microsecond; it is only done when a format uses %g.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	var id int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
 			break
 		}
+		id = id*10 + int64(c-'0')
 	}
-	if skip {
+	return id
+}
+
+// Send a log record to every filter which accepts its level.  For a checked
+// record, the first error from a writer is returned.
+func (log Logger) dispatch(rec *LogRecord) error {
+	if atomic.LoadInt32(&wantGoroutineID) != 0 && rec.goid == 0 {
+		rec.goid = goroutineID()
+	}
+	maxLen := 0
+	opts := log.options()
+	if opts != nil {
+		if opts.utc {
+			rec.Created = rec.Created.UTC()
+		}
+		opts.redact(rec)
+		opts.runHooks(rec)
+		maxLen = opts.maxMessageLen
+	}
+	defer rec.release()
+
+	// Pick the filters under the lock but write to them without it, so that a
+	// slow writer cannot hold up changes to the filters of any logger
+	type picked struct {
+		filt *Filter
+		st   *filterState
+	}
+	var buf [4]picked
+	picks := buf[:0]
+	filtersMu.RLock()
+	for _, filt := range log {
+		if rec.Level < opts.filterLevel(filt.level()) || rec.Level >= OFF {
+			continue
+		}
+		picks = append(picks, picked{filt, filt.state()})
+	}
+	filtersMu.RUnlock()
+
+	var first error
+	for _, p := range picks {
+		if err := p.filt.dispatch(p.st, rec, maxLen); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// dispatch writes a record picked by Logger.dispatch to the filter, unless the
+// filter has been closed since.  The record's message is cut to the filter's
+// limit, or else maxLen.
+func (filt *Filter) dispatch(st *filterState, rec *LogRecord, maxLen int) error {
+	st.writing.RLock()
+	defer st.writing.RUnlock()
+	if st.closed {
+		return nil
+	}
+
+	n := maxLen
+	if filtLen := atomic.LoadInt64(&st.maxLen); filtLen > 0 {
+		n = int(filtLen)
+	}
+	skip := atomic.LoadInt32(&st.skip)
+	if (skip == 0 || rec.depth == 0) && (n <= 0 || len(rec.Message) <= n) {
+		return filt.write(rec)
+	}
+
+	// Find the source again for filters which skip more frames; this must be
+	// done here, at a known depth below the logging method (one more than
+	// Logger.dispatch)
+	cp := *rec
+	cp.pooled = false
+	if skip > 0 && rec.depth > 0 {
+		cp.Source, cp.file = callerSource(rec.depth + 1 + int(skip))
+	}
+	cp.Message = truncateMessage(rec.Message, n)
+	return filt.write(&cp)
+}
+
+// Send a formatted log message internally
+func (log Logger) intLogf(lvl Level, format string, args ...interface{}) {
+	if log.skip(lvl) {
 		return
 	}
 
-	// Determine caller func
-	pc, _, lineno, ok := runtime.Caller(2)
-	src := ""
-	if ok {
-		src = fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), lineno)
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+
+	// Make the log record
+	src, file, depth := log.caller()
+	rec := newRecord(LogRecord{
+		Level:   lvl,
+		Created: time.Now(),
+		Source:  src,
+		Message: msg,
+		file:    file,
+		depth:   depth,
+	})
+
+	log.dispatch(rec)
+}
+
+// Send a formatted log message internally, returning the first writer error
+func (log Logger) intLogChecked(lvl Level, format string, args ...interface{}) error {
+	if log.skip(lvl) {
+		return nil
 	}
 
 	msg := format
@@ -208,91 +764,86 @@ func (log Logger) intLogf(lvl Level, format string, args ...interface{}) {
 	}
 
 	// Make the log record
-	rec := &LogRecord{
+	src, file, depth := log.caller()
+	rec := newRecord(LogRecord{
 		Level:   lvl,
 		Created: time.Now(),
 		Source:  src,
 		Message: msg,
+		file:    file,
+		depth:   depth,
+		checked: true,
+	})
+
+	return log.dispatch(rec)
+}
+
+// Send a formatted log message with fields internally
+func (log Logger) intLogFields(lvl Level, fields map[string]interface{}, format string, args ...interface{}) {
+	if log.skip(lvl) {
+		return
 	}
 
-	// Dispatch the logs
-	for _, filt := range log {
-		if lvl < filt.Level {
-			continue
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+
+	// Make the log record, copying the fields so the caller may reuse them
+	src, file, depth := log.caller()
+	rec := newRecord(LogRecord{
+		Level:   lvl,
+		Created: time.Now(),
+		Source:  src,
+		Message: msg,
+		file:    file,
+		depth:   depth,
+	})
+	if len(fields) > 0 {
+		rec.Fields = make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			rec.Fields[k] = v
 		}
-		filt.LogWrite(rec)
 	}
+
+	log.dispatch(rec)
 }
 
 // Send a closure log message internally
 func (log Logger) intLogc(lvl Level, closure func() string) {
-	skip := true
-
-	// Determine if any logging will be done
-	for _, filt := range log {
-		if lvl >= filt.Level {
-			skip = false
-			break
-		}
-	}
-	if skip {
+	if log.skip(lvl) {
 		return
 	}
 
-	// Determine caller func
-	pc, _, lineno, ok := runtime.Caller(2)
-	src := ""
-	if ok {
-		src = fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), lineno)
-	}
-
 	// Make the log record
-	rec := &LogRecord{
+	src, file, depth := log.caller()
+	rec := newRecord(LogRecord{
 		Level:   lvl,
 		Created: time.Now(),
 		Source:  src,
 		Message: closure(),
-	}
+		file:    file,
+		depth:   depth,
+	})
 
-	// Dispatch the logs
-	for _, filt := range log {
-		if lvl < filt.Level {
-			continue
-		}
-		filt.LogWrite(rec)
-	}
+	log.dispatch(rec)
 }
 
 // Send a log message with manual level, source, and message.
 func (log Logger) Log(lvl Level, source, message string) {
-	skip := true
-
-	// Determine if any logging will be done
-	for _, filt := range log {
-		if lvl >= filt.Level {
-			skip = false
-			break
-		}
-	}
-	if skip {
+	if log.skip(lvl) {
 		return
 	}
 
 	// Make the log record
-	rec := &LogRecord{
+	rec := newRecord(LogRecord{
 		Level:   lvl,
 		Created: time.Now(),
 		Source:  source,
 		Message: message,
-	}
+	})
 
-	// Dispatch the logs
-	for _, filt := range log {
-		if lvl < filt.Level {
-			continue
-		}
-		filt.LogWrite(rec)
-	}
+	log.dispatch(rec)
 }
 
 // Logf logs a formatted log message at the given log level, using the caller as
@@ -301,77 +852,69 @@ func (log Logger) Logf(lvl Level, format string, args ...interface{}) {
 	log.intLogf(lvl, format, args...)
 }
 
-// Logc logs a string returned by the closure at the given log level, using the caller as
-// its source.  If no log message would be written, the closure is never called.
-func (log Logger) Logc(lvl Level, closure func() string) {
-	log.intLogc(lvl, closure)
+// LogChecked logs a formatted log message at the given log level, like Logf,
+// but waits for it to be written and returns the first error from a writer,
+// e.g. to be sure a CRITICAL message is on disk before exiting.  Writers which
+// are Flushers are flushed after they are given the record, and any error
+// from Flush is returned; other writers cannot report errors.  Records which
+// no filter writes return nil.
+func (log Logger) LogChecked(lvl Level, format string, args ...interface{}) error {
+	return log.intLogChecked(lvl, format, args...)
 }
 
-// Finest logs a message at the finest log level.
-// See Debug for an explanation of the arguments.
-func (log Logger) Finest(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINEST
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		log.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
-	}
+// LogWithFields logs a formatted log message at the given log level with the
+// given key/value fields attached, using the caller as its source.  The text
+// formats render the fields as sorted key=value pairs after the message.
+func (log Logger) LogWithFields(lvl Level, fields map[string]interface{}, format string, args ...interface{}) {
+	log.intLogFields(lvl, fields, format, args...)
 }
 
-// Fine logs a message at the fine log level.
-// See Debug for an explanation of the arguments.
-func (log Logger) Fine(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINE
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		log.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+// LogError logs msg at the given log level with err's message in an "error"
+// field, using the caller as its source.  If err, or an error it wraps, has a
+// StackTrace method, as the errors of github.com/pkg/errors do, or err prints
+// more detail with %+v than its message, that is recorded in a "stack" field.
+// The stack of the innermost error which has one is used, being nearest to
+// where the error was created.  msg is not a format string.
+func (log Logger) LogError(lvl Level, err error, msg string) {
+	log.intLogFields(lvl, errorFields(err), "%s", msg)
+}
+
+// errorFields returns the fields LogError records for err
+func errorFields(err error) map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+	fields := map[string]interface{}{"error": err.Error()}
+	if stack := errorStack(err); stack != "" {
+		fields["stack"] = stack
 	}
+	return fields
 }
 
-// Debug is a utility method for debug log messages.
-// The behavior of Debug depends on the first argument:
-// - arg0 is a string
-//   When given a string as the first argument, this behaves like Logf but with
-//   the DEBUG log level: the first argument is interpreted as a format for the
-//   latter arguments.
-// - arg0 is a func()string
-//   When given a closure of type func()string, this logs the string returned by
-//   the closure iff it will be logged.  The closure runs at most one time.
-// - arg0 is interface{}
-//   When given anything else, the log message will be each of the arguments
-//   formatted with %v and separated by spaces (ala Sprint).
-func (log Logger) Debug(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = DEBUG
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		log.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+// errorStack returns the stack trace carried by err, if any
+func errorStack(err error) string {
+	var stack string
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		m := reflect.ValueOf(e).MethodByName("StackTrace")
+		if m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+			stack = strings.TrimSpace(fmt.Sprintf("%+v", m.Call(nil)[0].Interface()))
+		}
+	}
+	if stack != "" {
+		return stack
 	}
+	if _, ok := err.(fmt.Formatter); ok {
+		if verbose := fmt.Sprintf("%+v", err); verbose != err.Error() {
+			return verbose
+		}
+	}
+	return ""
+}
+
+// Logc logs a string returned by the closure at the given log level, using the caller as
+// its source.  If no log message would be written, the closure is never called.
+func (log Logger) Logc(lvl Level, closure func() string) {
+	log.intLogc(lvl, closure)
 }
 
 // Trace logs a message at the trace log level.
@@ -380,6 +923,9 @@ func (log Logger) Trace(arg0 interface{}, args ...interface{}) {
 	const (
 		lvl = TRACE
 	)
+	if log.skip(lvl) {
+		return
+	}
 	switch first := arg0.(type) {
 	case string:
 		// Use the string as a format string
@@ -399,6 +945,9 @@ func (log Logger) Info(arg0 interface{}, args ...interface{}) {
 	const (
 		lvl = INFO
 	)
+	if log.skip(lvl) {
+		return
+	}
 	switch first := arg0.(type) {
 	case string:
 		// Use the string as a format string
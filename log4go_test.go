@@ -3,15 +3,35 @@
 package log4go
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
+	"unicode/utf8"
 )
 
 const testLogFile = "_logtest.log"
@@ -76,6 +96,215 @@ func TestFormatLogRecord(t *testing.T) {
 	}
 }
 
+func TestFormatLogRecordTime(t *testing.T) {
+	rec := newLogRecord(INFO, "source", "message")
+	if got, want := FormatLogRecord("%.3T|%T|%.6T", rec), "23:31:30.123 UTC|23:31:30 UTC|23:31:30.123456 UTC\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec.Created = time.Date(2014, time.August, 5, 9, 30, 0, 0, time.FixedZone("XST", -(5*3600+30*60)))
+	if got, want := FormatLogRecord("%D %T %z %Z", rec), "2014/08/05 09:30:00 XST -0530 XST\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := formatLogRecord("[%D %T] %M", rec, time.RFC3339), "[2014/08/05 2014-08-05T09:30:00-05:30] message\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatLogRecordVerbs(t *testing.T) {
+	rec := &LogRecord{
+		Level:   WARNING,
+		Created: now,
+		Source:  "github.com/chespinoza/log4go.TestFormatLogRecordVerbs:42",
+		Message: "message",
+		Fields:  map[string]interface{}{"key": "value"},
+		file:    "/src/log4go/log4go_test.go:42",
+		goid:    7,
+	}
+	// Unknown verbs are dropped, "%%" is a literal % and so is a lone % at the end
+	for format, want := range map[string]string{
+		"%T|%.3T|%t|%z|%Z|%D|%I|%E|%d": "23:31:30 UTC|23:31:30.123 UTC|23:31|+0000|UTC|2009/02/13|2009-02-13T23:31:30.123456789Z|1234567890123|13/02/09\n",
+		"%L|%S|%F|%s|%M|%g":            "WARN|github.com/chespinoza/log4go.TestFormatLogRecordVerbs:42|log4go.TestFormatLogRecordVerbs|log4go_test.go:42|message key=value|7\n",
+		"plain text":                   "plain text\n",
+		"%%|%?|%.x|%.0T|%":             "%||x|0T|%\n",
+		"%DT%T%":                       "2009/02/13T23:31:30 UTC%\n",
+		"%Mand %Mx%%g":                 "message key=valueand message key=valuex%g\n",
+		"100%% done: %M":               "100% done: message key=value\n",
+		"%%%%%L":                       "%%WARN\n",
+	} {
+		if got := FormatLogRecord(format, rec); got != want {
+			t.Errorf("FormatLogRecord(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+// gatedWriter holds up the first write until its gate is closed, then passes
+// each write on as a line
+type gatedWriter struct {
+	gate  chan struct{}
+	lines chan string
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.lines <- string(p)
+	return len(p), nil
+}
+
+func TestFormatWriteTime(t *testing.T) {
+	w := &gatedWriter{gate: make(chan struct{}), lines: make(chan string, 2)}
+	log := make(Logger).AddFilter("format", FINEST, NewFormatLogWriter(w, "%D %T|%D %w"))
+	defer log.Close()
+	log.SetUTC(true)
+
+	// The first record holds up the writer while the second waits in its queue
+	log.Info("first")
+	called := time.Now().UTC().Truncate(time.Second)
+	log.Info("second")
+	time.Sleep(1500 * time.Millisecond)
+	close(w.gate)
+	<-w.lines
+	line := <-w.lines
+
+	parts := strings.Split(strings.TrimSuffix(line, "\n"), "|")
+	if len(parts) != 2 {
+		t.Fatalf("Unexpected line %q", line)
+	}
+	created, err := time.Parse("2006/01/02 15:04:05 MST", parts[0])
+	if err != nil {
+		t.Fatalf("Could not parse %%D %%T in %q: %s", line, err)
+	}
+	written, err := time.Parse("2006/01/02 15:04:05 MST", parts[1])
+	if err != nil {
+		t.Fatalf("Could not parse %%D %%w in %q: %s", line, err)
+	}
+	if created.Before(called) || created.Sub(called) > time.Second {
+		t.Errorf("Expected %%D %%T to be the call time %v, found %q", called, line)
+	}
+	if written.Sub(created) < time.Second {
+		t.Errorf("Expected %%w to be at least a second after %%T, found %q", line)
+	}
+}
+
+func TestFormatLogRecordProcess(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("Hostname: %s", err)
+	}
+	rec := newLogRecord(INFO, "source", "message")
+	if got, want := FormatLogRecord("%H[%P] %M", rec), fmt.Sprintf("%s[%d] message\n", host, os.Getpid()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatLogRecordLevels(t *testing.T) {
+	for lvl, want := range map[Level]string{
+		FINEST:   "N 0 FNST\n",
+		FINE:     "F 1 FINE\n",
+		DEBUG:    "D 2 DEBG\n",
+		TRACE:    "T 3 TRAC\n",
+		INFO:     "I 4 INFO\n",
+		WARNING:  "W 5 WARN\n",
+		ERROR:    "E 6 EROR\n",
+		CRITICAL: "C 7 CRIT\n",
+	} {
+		if got := FormatLogRecord("%l %n %L", newLogRecord(lvl, "source", "message")); got != want {
+			t.Errorf("%s: got %q, want %q", lvl, got, want)
+		}
+	}
+}
+
+func TestFormatLogRecordFields(t *testing.T) {
+	rec := newLogRecord(INFO, "source", "message")
+	for _, fields := range []map[string]interface{}{nil, {}} {
+		rec.Fields = fields
+		if got, want := FormatLogRecord("[%L] %M", rec), "[INFO] message\n"; got != want {
+			t.Errorf("Fields %v: got %q, want %q", fields, got, want)
+		}
+	}
+
+	rec.Fields = map[string]interface{}{"user": "bob", "attempt": 3, "admin": false}
+	want := "[INFO] message admin=false attempt=3 user=bob\n"
+	for i := 0; i < 10; i++ {
+		if got := FormatLogRecord("[%L] %M", rec); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestLogWithFields(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger).AddFilter("rec", INFO, w)
+
+	fields := map[string]interface{}{"request": 42}
+	l.LogWithFields(INFO, fields, "handled %s", "request")
+	fields["request"] = 43
+
+	if len(w.records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(w.records))
+	}
+	rec := w.records[0]
+	if rec.Message != "handled request" || rec.Fields["request"] != 42 {
+		t.Errorf("Unexpected record: %q %v", rec.Message, rec.Fields)
+	}
+	if !strings.Contains(rec.Source, "TestLogWithFields") {
+		t.Errorf("Expected source to be the caller, got %q", rec.Source)
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger).AddFilter("rec", INFO, w)
+
+	sl := slog.New(NewSlogHandler(l)).With("service", "api").WithGroup("req")
+	sl.Debug("suppressed")
+	sl.Info("handled", "id", 7, slog.Group("user", "name", "bob"))
+	sl.Error("failed")
+
+	if len(w.records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(w.records))
+	}
+
+	rec := w.records[0]
+	if rec.Level != INFO || rec.Message != "handled" {
+		t.Errorf("Unexpected record: %s %q", rec.Level, rec.Message)
+	}
+	want := map[string]string{"service": "api", "req.id": "7", "req.user.name": "bob"}
+	if len(rec.Fields) != len(want) {
+		t.Errorf("Expected fields %v, got %v", want, rec.Fields)
+	}
+	for k, v := range want {
+		if got := fmt.Sprint(rec.Fields[k]); got != v {
+			t.Errorf("Field %s: got %q, want %q", k, got, v)
+		}
+	}
+	if !strings.Contains(rec.Source, "TestSlogHandler") {
+		t.Errorf("Expected source to be the caller, got %q", rec.Source)
+	}
+
+	if rec := w.records[1]; rec.Level != ERROR || rec.Fields["service"] != "api" {
+		t.Errorf("Unexpected record: %s %v", rec.Level, rec.Fields)
+	}
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	rec := newLogRecord(ERROR, "source", `say "hi" to everyone`)
+	rec.Fields = map[string]interface{}{"query": "a=b", "n": 3}
+	want := `time=2009-02-13T23:31:30Z level=EROR source=source msg="say \"hi\" to everyone" n=3 query="a=b"` + "\n"
+	if got := FormatLogRecord(FORMAT_LOGFMT, rec); got != want {
+		t.Errorf("got  %q", got)
+		t.Errorf("want %q", want)
+	}
+
+	rec = newLogRecord(INFO, "", "")
+	want = `time=2009-02-13T23:31:30Z level=INFO source="" msg=""` + "\n"
+	if got := FormatLogfmt(rec); got != want {
+		t.Errorf("got  %q", got)
+		t.Errorf("want %q", want)
+	}
+}
+
 var logRecordWriteTests = []struct {
 	Test    string
 	Record  *LogRecord
@@ -94,7 +323,7 @@ var logRecordWriteTests = []struct {
 }
 
 func TestConsoleLogWriter(t *testing.T) {
-	console := make(ConsoleLogWriter)
+	console := &ConsoleLogWriter{rec: make(chan *LogRecord)}
 
 	r, w := io.Pipe()
 	go console.run(w)
@@ -115,6 +344,93 @@ func TestConsoleLogWriter(t *testing.T) {
 	}
 }
 
+func TestConsoleLogWriterColors(t *testing.T) {
+	defer os.Unsetenv("NO_COLOR")
+	os.Unsetenv("NO_COLOR")
+
+	render := func(w *ConsoleLogWriter) string {
+		buf := new(bytes.Buffer)
+		w.rec = make(chan *LogRecord, 1)
+		w.LogWrite(newLogRecord(ERROR, "source", "message"))
+		close(w.rec)
+		w.run(buf)
+		return buf.String()
+	}
+
+	// Not a terminal, so colors are not written unless forced
+	if got := render(new(ConsoleLogWriter).SetColors(true)); strings.Contains(got, "\x1b[") {
+		t.Errorf("Unexpected escape codes when not a terminal: %q", got)
+	}
+	if got, want := render(new(ConsoleLogWriter).SetColors(true).SetForceColor(true)), "[\x1b[31mEROR\x1b[0m]"; !strings.Contains(got, want) {
+		t.Errorf("Expected forced color output to contain %q, got %q", want, got)
+	}
+
+	os.Setenv("NO_COLOR", "1")
+	if got := render(new(ConsoleLogWriter).SetColors(true).SetForceColor(true)); strings.Contains(got, "\x1b[") {
+		t.Errorf("Unexpected escape codes with NO_COLOR set: %q", got)
+	}
+}
+
+func TestConsoleLogWriterFormat(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := &ConsoleLogWriter{rec: make(chan *LogRecord, 1)}
+	w.SetFormat("%L %S: %M")
+	w.LogWrite(newLogRecord(WARNING, "source", "message"))
+	close(w.rec)
+	w.run(buf)
+
+	if got, want := buf.String(), "WARN source: message\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestConsoleLogWriterSplitStreams(t *testing.T) {
+	render := func(split bool) (string, string) {
+		out, errw := new(bytes.Buffer), new(bytes.Buffer)
+		w := &ConsoleLogWriter{rec: make(chan *LogRecord, 2), errout: errw}
+		w.SetSplitStreams(split)
+		w.LogWrite(newLogRecord(INFO, "source", "info message"))
+		w.LogWrite(newLogRecord(ERROR, "source", "error message"))
+		close(w.rec)
+		w.run(out)
+		return out.String(), errw.String()
+	}
+
+	out, errw := render(true)
+	if !strings.Contains(out, "info message") || strings.Contains(out, "error message") {
+		t.Errorf("out = %q, want only the INFO record", out)
+	}
+	if !strings.Contains(errw, "error message") || strings.Contains(errw, "info message") {
+		t.Errorf("err = %q, want only the ERROR record", errw)
+	}
+
+	out, errw = render(false)
+	if !strings.Contains(out, "info message") || !strings.Contains(out, "error message") || errw != "" {
+		t.Errorf("unsplit: out = %q, err = %q, want both records in out", out, errw)
+	}
+}
+
+func TestConsoleLogWriterLevelWriter(t *testing.T) {
+	out, errw, audit := new(bytes.Buffer), new(bytes.Buffer), new(bytes.Buffer)
+	w := &ConsoleLogWriter{rec: make(chan *LogRecord, 3), errout: errw, split: true}
+	w.SetFormat("%L %M").SetLevelWriter(INFO, INFO, audit)
+	w.LogWrite(newLogRecord(DEBUG, "source", "debug message"))
+	w.LogWrite(newLogRecord(INFO, "source", "info message"))
+	w.LogWrite(newLogRecord(ERROR, "source", "error message"))
+	close(w.rec)
+	w.run(out)
+
+	if got, want := audit.String(), "INFO info message\n"; got != want {
+		t.Errorf("audit = %q, want %q", got, want)
+	}
+	if got, want := out.String(), "DEBG debug message\n"; got != want {
+		t.Errorf("out = %q, want %q", got, want)
+	}
+	if got, want := errw.String(), "EROR error message\n"; got != want {
+		t.Errorf("err = %q, want %q", got, want)
+	}
+}
+
 func TestFileLogWriter(t *testing.T) {
 	defer func(buflen int) {
 		LogBufferLength = buflen
@@ -138,123 +454,3006 @@ func TestFileLogWriter(t *testing.T) {
 	}
 }
 
-func TestXMLLogWriter(t *testing.T) {
-	defer func(buflen int) {
-		LogBufferLength = buflen
-	}(LogBufferLength)
-	LogBufferLength = 0
+func TestFileLogWriterRotateRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "rename.log")
 
-	w := NewXMLLogWriter(testLogFile, false)
+	w := NewFileLogWriter(logfile, true).SetFormat("%M")
 	if w == nil {
 		t.Fatalf("Invalid return: w should not be nil")
 	}
-	defer os.Remove(testLogFile)
 
-	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+	// A tailer following the file as it is being written
+	tail, err := os.Open(logfile)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer tail.Close()
+
+	var before bytes.Buffer
+	for i := 0; i < 10; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("before %d", i)))
+		fmt.Fprintf(&before, "before %d\n", i)
+	}
+	w.Rotate()
+	w.LogWrite(newLogRecord(INFO, "source", "after"))
 	w.Close()
-	runtime.Gosched()
 
-	if contents, err := ioutil.ReadFile(testLogFile); err != nil {
-		t.Errorf("read(%q): %s", testLogFile, err)
-	} else if len(string(contents)) != 185 {
-		t.Errorf("malformed xmllog: %q (expected: 185, actual: %d characters)", string(contents), len(string(contents)))
+	// The tailer still sees the whole of the old file
+	if contents, err := ioutil.ReadAll(tail); err != nil || string(contents) != before.String() {
+		t.Errorf("Expected the tailer to read %q, found %q (%v)", before.String(), contents, err)
+	}
+
+	// The old file was moved aside rather than copied
+	tailInfo, _ := tail.Stat()
+	rotated := ""
+	matches, _ := filepath.Glob(logfile + ".*")
+	for _, name := range matches {
+		if info, err := os.Stat(name); err == nil && os.SameFile(info, tailInfo) {
+			rotated = name
+		}
+	}
+	if rotated == "" {
+		t.Fatalf("Expected the old file to have been renamed, found %v", matches)
+	}
+	if contents, _ := ioutil.ReadFile(rotated); string(contents) != before.String() {
+		t.Errorf("Expected %s to hold %q, found %q", rotated, before.String(), contents)
+	}
+
+	// And a new file was started in its place
+	if info, err := os.Stat(logfile); err != nil || os.SameFile(info, tailInfo) {
+		t.Errorf("Expected a new file at %s (%v)", logfile, err)
+	}
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "after\n" {
+		t.Errorf("Expected %s to hold %q, found %q", logfile, "after\n", contents)
 	}
 }
 
-func TestLogger(t *testing.T) {
-	sl := NewDefaultLogger(WARNING)
-	if sl == nil {
-		t.Fatalf("NewDefaultLogger should never return nil")
+func TestFileLogWriterFlushInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
-	if lw, exist := sl["stdout"]; lw == nil || exist != true {
-		t.Fatalf("NewDefaultLogger produced invalid logger (DNE or nil)")
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "flush.log")
+
+	const interval = 500 * time.Millisecond
+	w := NewFileLogWriter(logfile, false).SetFormat("%M").SetFlushInterval(interval)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
 	}
-	if sl["stdout"].Level != WARNING {
-		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect level)")
+	defer w.Close()
+
+	read := func() string {
+		contents, _ := ioutil.ReadFile(logfile)
+		return string(contents)
 	}
-	if len(sl) != 1 {
-		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect map count)")
+
+	start := time.Now()
+	w.LogWrite(newLogRecord(INFO, "source", "buffered"))
+	time.Sleep(interval / 5)
+	if contents := read(); contents != "" && time.Since(start) < interval {
+		t.Errorf("Expected the INFO record to be buffered, found %q", contents)
 	}
 
-	//func (l *Logger) AddFilter(name string, level int, writer LogWriter) {}
-	l := make(Logger)
-	l.AddFilter("stdout", DEBUG, NewConsoleLogWriter())
-	if lw, exist := l["stdout"]; lw == nil || exist != true {
-		t.Fatalf("AddFilter produced invalid logger (DNE or nil)")
+	deadline := time.Now().Add(5 * interval)
+	for read() != "buffered\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the buffer to be flushed, found %q", read())
+		}
+		time.Sleep(interval / 10)
 	}
-	if l["stdout"].Level != DEBUG {
-		t.Fatalf("AddFilter produced invalid logger (incorrect level)")
+
+	// Errors are written out straight away
+	w.LogWrite(newLogRecord(INFO, "source", "info"))
+	w.LogWrite(newLogRecord(ERROR, "source", "error"))
+	deadline = time.Now().Add(interval / 2)
+	for read() != "buffered\ninfo\nerror\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the ERROR record to be flushed immediately, found %q", read())
+		}
+		time.Sleep(5 * time.Millisecond)
 	}
-	if len(l) != 1 {
-		t.Fatalf("AddFilter produced invalid logger (incorrect map count)")
+}
+
+func TestLoggerFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "flush.log")
 
-	//func (l *Logger) Warn(format string, args ...interface{}) error {}
-	if err := l.Warn("%s %d %#v", "Warning:", 1, []int{}); err.Error() != "Warning: 1 []int{}" {
-		t.Errorf("Warn returned invalid error: %s", err)
+	w := NewFileLogWriter(logfile, false).SetFormat("%M").SetBufferSize(4096).SetFlushInterval(time.Hour)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
 	}
+	log := make(Logger)
+	log.AddFilter("file", FINEST, w)
+	log.AddFilter("memory", FINEST, NewMemoryLogWriter(10)) // not a Flusher
+	defer log.Close()
 
-	//func (l *Logger) Error(format string, args ...interface{}) error {}
-	if err := l.Error("%s %d %#v", "Error:", 10, []string{}); err.Error() != "Error: 10 []string{}" {
-		t.Errorf("Error returned invalid error: %s", err)
+	log.Info("buffered")
+	if err := log.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "buffered\n" {
+		t.Errorf("After Flush, file contains %q, want %q", contents, "buffered\n")
 	}
+}
 
-	//func (l *Logger) Critical(format string, args ...interface{}) error {}
-	if err := l.Critical("%s %d %#v", "Critical:", 100, []int64{}); err.Error() != "Critical: 100 []int64{}" {
-		t.Errorf("Critical returned invalid error: %s", err)
+func TestFileLogWriterCreatesDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
+	defer os.RemoveAll(dir)
 
-	// Already tested or basically untestable
-	//func (l *Logger) Log(level int, source, message string) {}
-	//func (l *Logger) Logf(level int, format string, args ...interface{}) {}
-	//func (l *Logger) intLogf(level int, format string, args ...interface{}) string {}
-	//func (l *Logger) Finest(format string, args ...interface{}) {}
-	//func (l *Logger) Fine(format string, args ...interface{}) {}
-	//func (l *Logger) Debug(format string, args ...interface{}) {}
-	//func (l *Logger) Trace(format string, args ...interface{}) {}
-	//func (l *Logger) Info(format string, args ...interface{}) {}
+	logfile := filepath.Join(dir, "a", "b", "app.log")
+	w := NewFileLogWriter(logfile, true).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "nested"))
+	w.Close()
+
+	if contents, err := ioutil.ReadFile(logfile); err != nil || string(contents) != "nested\n" {
+		t.Errorf("Expected %s to hold %q, found %q (%v)", logfile, "nested\n", contents, err)
+	}
+
+	// Nothing to rotate away in a fresh directory
+	if matches, _ := filepath.Glob(logfile + ".*"); len(matches) != 0 {
+		t.Errorf("Expected no rotated files, found %v", matches)
+	}
+
+	// The directory mode can be given in the configuration
+	logfile = filepath.Join(dir, "c", "d", "app.log")
+	props := map[string]string{"filename": logfile, "dirperm": "0700"}
+	flw, err := propsToFileLogWriter("test", props, true)
+	if err != nil {
+		t.Fatalf("propsToFileLogWriter: %s", err)
+	}
+	flw.Close()
+
+	for _, d := range []string{filepath.Join(dir, "c"), filepath.Join(dir, "c", "d")} {
+		info, err := os.Stat(d)
+		if err != nil {
+			t.Fatalf("stat: %s", err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+			t.Errorf("Expected %s to have mode 0700, found %v", d, info.Mode().Perm())
+		}
+	}
+}
+
+func TestFileLogWriterPerm(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("file modes are not supported on " + runtime.GOOS)
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "perm.log")
+
+	w := NewFileLogWriter(logfile, true).SetFilePerm(0600)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.Rotate()
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.Close()
+
+	matches, _ := filepath.Glob(logfile + "*")
+	if len(matches) != 2 {
+		t.Fatalf("Expected the log file and one rotated file, found %v", matches)
+	}
+	for _, name := range matches {
+		if info, err := os.Stat(name); err != nil {
+			t.Errorf("stat: %s", err)
+		} else if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s to have mode 0600, found %v", name, info.Mode().Perm())
+		}
+	}
+
+	// The mode can be given in the configuration, in octal
+	logfile = filepath.Join(dir, "config.log")
+	props := map[string]string{"filename": logfile, "perm": "0640"}
+	flw, err := propsToFileLogWriter("test", props, true)
+	if err != nil {
+		t.Fatalf("propsToFileLogWriter: %s", err)
+	}
+	flw.Close()
+	if info, err := os.Stat(logfile); err != nil {
+		t.Errorf("stat: %s", err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected %s to have mode 0640, found %v", logfile, info.Mode().Perm())
+	}
+
+	if _, ok := parsePerm("0649"); ok {
+		t.Errorf("Expected 0649 to be rejected as a mode")
+	}
+}
+
+func TestFileLogWriterSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("symlinks are not reliably available on " + runtime.GOOS)
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "app.log")
+	link := filepath.Join(dir, "current")
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetCurrentSymlink(link)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			w.Rotate()
+		}
+		msg := fmt.Sprintf("generation %d", i)
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+
+		// Wait for the record to make it to the file
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			contents, _ := ioutil.ReadFile(link)
+			if string(contents) == msg+"\n" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to resolve to a file holding %q, found %q", link, msg+"\n", contents)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		linkInfo, err := os.Stat(link)
+		if err != nil {
+			t.Fatalf("stat: %s", err)
+		}
+		if fileInfo, err := os.Stat(logfile); err != nil || !os.SameFile(linkInfo, fileInfo) {
+			t.Errorf("Expected %s to point at %s (%v)", link, logfile, err)
+		}
+	}
+	w.Close()
+
+	if target, err := os.Readlink(link); err != nil || target != "app.log" {
+		t.Errorf("Expected a relative link to %q, found %q (%v)", "app.log", target, err)
+	}
+}
+
+func TestFileLogWriterDedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "dedup.log")
+
+	w := NewFileLogWriter(logfile, false).SetFormat("[%T] [%L] %M").SetDedup(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for i := 0; i < 5; i++ {
+		rec := newLogRecord(ERROR, "source", "disk full")
+		rec.Created = now.Add(time.Duration(i) * time.Second)
+		w.LogWrite(rec)
+	}
+	w.Close()
+
+	contents, _ := ioutil.ReadFile(logfile)
+	if want := "[23:31:30 UTC] [EROR] disk full\n[23:31:34 UTC] [EROR] last message repeated 4 times\n"; string(contents) != want {
+		t.Errorf("Expected %q, found %q", want, contents)
+	}
+
+	// The count isn't held back for longer than the hold time
+	os.Remove(logfile)
+	w = NewFileLogWriter(logfile, false).SetFormat("%M").SetDedup(true).SetDedupHold(50 * time.Millisecond)
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", "again"))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		contents, _ := ioutil.ReadFile(logfile)
+		if string(contents) == "again\nlast message repeated 2 times\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the repeat count, found %q", contents)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileLogWriterCompress(t *testing.T) {
+	const logfile = "_compress.log"
+
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	cleanup := func() {
+		names, _ := filepath.Glob(logfile + "*")
+		for _, name := range names {
+			os.Remove(name)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	w := NewFileLogWriter(logfile, true).SetFormat("[%L] %M").SetRotateLines(2).SetCompressRotated(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.LogWrite(newLogRecord(INFO, "source", "third"))
+	w.Close()
+
+	want := "[INFO] first\n[INFO] second\n"
+	compressed, _ := filepath.Glob(logfile + ".*.gz")
+	found := false
+	for _, name := range compressed {
+		fd, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("open(%q): %s", name, err)
+		}
+		gz, err := gzip.NewReader(fd)
+		if err != nil {
+			fd.Close()
+			t.Fatalf("gzip(%q): %s", name, err)
+		}
+		contents, err := ioutil.ReadAll(gz)
+		fd.Close()
+		if err != nil {
+			t.Fatalf("read(%q): %s", name, err)
+		}
+		if string(contents) == want {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("No compressed rotated file contained %q (found %v)", want, compressed)
+	}
+
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "[INFO] third\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
+
+func TestFileLogWriterDailyClock(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "daily.log")
+
+	var mu sync.Mutex
+	clock := time.Date(2009, 2, 13, 23, 59, 0, 0, time.UTC)
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+
+	w := NewFileLogWriter(logfile, true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.setClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}).SetFormat("%M").SetRotateDaily(true)
+
+	w.LogWrite(newLogRecord(INFO, "source", "friday"))
+	w.Flush()
+	advance(30 * time.Second)
+	w.LogWrite(newLogRecord(INFO, "source", "still friday"))
+	w.Flush()
+	advance(time.Minute)
+	w.LogWrite(newLogRecord(INFO, "source", "saturday"))
+	w.Close()
+
+	if contents, err := ioutil.ReadFile(logfile + ".001"); err != nil {
+		t.Errorf("Expected a rotated file: %s", err)
+	} else if string(contents) != "friday\nstill friday\n" {
+		t.Errorf("Unexpected rotated log contents: %q", contents)
+	}
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "saturday\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
+
+func TestFileLogWriterRotateNamePattern(t *testing.T) {
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, "app.log")
+	clock := time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC)
+
+	w := NewFileLogWriter(logfile, true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.setClock(func() time.Time { return clock }).SetFormat("%M").SetRotateNamePattern("app-%Y%m%d-%H%M%S.log")
+
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.Rotate()
+	w.LogWrite(newLogRecord(INFO, "source", "same second"))
+	w.Rotate()
+	w.LogWrite(newLogRecord(INFO, "source", "active"))
+	w.Close()
+
+	for name, want := range map[string]string{
+		"app-20090213-233130.log":     "first\n",
+		"app-20090213-233130.log.001": "same second\n",
+		"app.log":                     "active\n",
+	} {
+		if contents, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil {
+			t.Errorf("read(%q): %s", name, err)
+		} else if string(contents) != want {
+			t.Errorf("%s contains %q, want %q", name, contents, want)
+		}
+	}
+}
+
+func TestFileLogWriterReopenIfMissing(t *testing.T) {
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, "reopen.log")
+
+	w := NewFileLogWriter(logfile, false).SetFormat("%M").SetReopenIfMissing(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+
+	w.LogWrite(newLogRecord(INFO, "source", "before"))
+	w.Flush()
+	moved := filepath.Join(dir, "reopen.log.1")
+	if err := os.Rename(logfile, moved); err != nil {
+		t.Fatalf("rename: %s", err)
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "after move"))
+	w.Flush()
+	if err := os.Remove(logfile); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "after delete"))
+	w.Close()
+
+	if contents, _ := ioutil.ReadFile(moved); string(contents) != "before\n" {
+		t.Errorf("Moved file contains %q, want only the record before the move", contents)
+	}
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "after delete\n" {
+		t.Errorf("Reopened file contains %q", contents)
+	}
+}
+
+func TestFileLogWriterOnError(t *testing.T) {
+	defer func(delay time.Duration) {
+		fileRetryDelay = delay
+	}(fileRetryDelay)
+	fileRetryDelay = time.Millisecond
+
+	// Each writer's file is closed, so every write fails
+	failing := func(onError func(error) Action) *FileLogWriter {
+		w := NewFileLogWriter(filepath.Join(t.TempDir(), "failing.log"), false).SetOnError(onError)
+		if w == nil {
+			t.Fatalf("Invalid return: w should not be nil")
+		}
+		w.file.Close()
+		return w
+	}
+
+	calls := 0
+	w := failing(func(err error) Action {
+		calls++
+		return Disable
+	})
+	for i := 0; i < 5; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", "message"))
+	}
+	w.Close()
+	if calls != 1 {
+		t.Errorf("Disable: error handler called %d times, want 1", calls)
+	}
+	if got, want := w.Stats(), (WriterStats{Errors: 1, Dropped: 4}); got != want {
+		t.Errorf("Disable: Stats = %+v, want %+v", got, want)
+	}
+
+	calls = 0
+	w = failing(func(err error) Action {
+		if calls++; calls < 3 {
+			return Retry
+		}
+		return Drop
+	})
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.Close()
+	if calls != 4 {
+		t.Errorf("Retry then Drop: error handler called %d times, want 4", calls)
+	}
+	if got, want := w.Stats(), (WriterStats{Errors: 4}); got != want {
+		t.Errorf("Retry then Drop: Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestFileLogWriterRotateLines(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "lines.log")
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetHeadFoot("header", "trailer").SetRotateLines(3)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for i := 0; i < 7; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("record %d", i)))
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "a\nthree line\nrecord"))
+	w.Close()
+
+	for name, want := range map[string]string{
+		".001": "header\nrecord 0\nrecord 1\nrecord 2\ntrailer\n",
+		".002": "header\nrecord 3\nrecord 4\nrecord 5\ntrailer\n",
+		".003": "header\nrecord 6\ntrailer\n",
+		"":     "header\na\nthree line\nrecord\ntrailer\n",
+	} {
+		if contents, err := ioutil.ReadFile(logfile + name); err != nil {
+			t.Errorf("read(%q): %s", logfile+name, err)
+		} else if string(contents) != want {
+			t.Errorf("%s contains %q, want %q", logfile+name, contents, want)
+		}
+	}
+}
+
+func TestFileLogWriterAppend(t *testing.T) {
+	for _, test := range []struct {
+		append bool
+		want   string
+	}{
+		{true, "existing\nappended\n"},
+		{false, "appended\n"},
+	} {
+		logfile := filepath.Join(t.TempDir(), "append.log")
+		if err := ioutil.WriteFile(logfile, []byte("existing\n"), 0644); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+
+		w := NewFileLogWriter(logfile, false)
+		if w == nil {
+			t.Fatalf("Invalid return: w should not be nil")
+		}
+		w.SetFormat("%M").SetAppend(test.append)
+		w.LogWrite(newLogRecord(INFO, "source", "appended"))
+		w.Close()
+
+		if contents, err := ioutil.ReadFile(logfile); err != nil {
+			t.Errorf("read(%q): %s", logfile, err)
+		} else if string(contents) != test.want {
+			t.Errorf("SetAppend(%v): file contains %q, want %q", test.append, contents, test.want)
+		}
+	}
+}
+
+func TestLineSeparator(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "crlf.log")
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetLineSeparator("\r\n").SetHeadFoot("header", "trailer").SetRotateLines(2)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("record %d", i)))
+	}
+	w.Close()
+
+	for name, want := range map[string]string{
+		".001": "header\r\nrecord 0\r\nrecord 1\r\ntrailer\r\n",
+		"":     "header\r\nrecord 2\r\ntrailer\r\n",
+	} {
+		if contents, err := ioutil.ReadFile(logfile + name); err != nil {
+			t.Errorf("read(%q): %s", logfile+name, err)
+		} else if string(contents) != want {
+			t.Errorf("%s contains %q, want %q", logfile+name, contents, want)
+		}
+	}
+
+	for _, format := range []string{"", "%M"} {
+		buf := new(bytes.Buffer)
+		console := new(ConsoleLogWriter).SetFormat(format).SetLineSeparator("\r\n")
+		console.rec = make(chan *LogRecord, 1)
+		console.LogWrite(newLogRecord(INFO, "source", "message"))
+		close(console.rec)
+		console.run(buf)
+		if got := buf.String(); !strings.HasSuffix(got, "message\r\n") {
+			t.Errorf("console format %q: got %q, want it terminated by CRLF", format, got)
+		}
+	}
+}
+
+func TestFileLogWriterConcurrent(t *testing.T) {
+	const goroutines, records = 20, 200
+	logfile := filepath.Join(t.TempDir(), "concurrent.log")
+
+	log := make(Logger)
+	w := NewFileLogWriter(logfile, true).SetFormat("[%L] %M").SetRotateLines(500)
+	log.AddFilter("file", FINEST, w)
+
+	padding := strings.Repeat("x", 200)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				log.Info("g=%d i=%d %s end", g, i, padding)
+			}
+		}(g)
+	}
+	wg.Wait()
+	log.Close()
+
+	files, err := filepath.Glob(logfile + "*")
+	if err != nil {
+		t.Fatalf("glob: %s", err)
+	}
+	line := regexp.MustCompile(`^\[INFO\] g=(\d+) i=(\d+) x{200} end$`)
+	seen := make(map[string]bool)
+	for _, name := range files {
+		contents, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read(%q): %s", name, err)
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+			m := line.FindStringSubmatch(l)
+			if m == nil {
+				t.Fatalf("%s: garbled line %q", name, l)
+			}
+			seen[m[1]+"/"+m[2]] = true
+		}
+	}
+	if len(seen) != goroutines*records {
+		t.Errorf("Expected %d distinct records, got %d", goroutines*records, len(seen))
+	}
+}
+
+func TestFileLogWriterDailyRestart(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "restart.log")
+	clock := time.Date(2009, 2, 13, 9, 0, 0, 0, time.Local)
+
+	// The file was last written yesterday, before the process restarted
+	if err := ioutil.WriteFile(logfile, []byte("yesterday\n"), 0644); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	yesterday := clock.AddDate(0, 0, -1)
+	if err := os.Chtimes(logfile, yesterday, yesterday); err != nil {
+		t.Fatalf("chtimes: %s", err)
+	}
+
+	w := NewFileLogWriter(logfile, false)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.setClock(func() time.Time { return clock }).SetFormat("%M").SetRotate(true).SetRotateDaily(true)
+	w.LogWrite(newLogRecord(INFO, "source", "today"))
+	w.Close()
+
+	if contents, err := ioutil.ReadFile(logfile + ".001"); err != nil {
+		t.Errorf("Expected yesterday's file to be rotated: %s", err)
+	} else if string(contents) != "yesterday\n" {
+		t.Errorf("Unexpected rotated log contents: %q", contents)
+	}
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "today\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
+
+func TestFileLogWriterHourly(t *testing.T) {
+	const logfile = "_hourly.log"
+
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	cleanup := func() {
+		names, _ := filepath.Glob(logfile + "*")
+		for _, name := range names {
+			os.Remove(name)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetRotateHourly(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+
+	// Pretend the file was opened during the previous hour
+	opened := w.hourly_opentime.Add(-time.Hour)
+	w.hourly_opentime = opened
+	w.LogWrite(newLogRecord(INFO, "source", "next hour"))
+	w.Close()
+
+	rotated := logfile + opened.Format(".2006010215")
+	if _, err := os.Stat(rotated); err != nil {
+		t.Errorf("Expected hourly rotated file %s: %s", rotated, err)
+	}
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "next hour\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	start := time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"* * * * *", "2009-02-13 23:32"},
+		{"0 0,12", "2009-02-14 00:00"},
+		{"*/15 *", "2009-02-13 23:45"},
+		{"30 9-17 * * 1-5", "2009-02-16 09:30"},
+		{"0 0 1 * *", "2009-03-01 00:00"},
+		{"0 0 * * 7", "2009-02-15 00:00"},
+		{"0 0 29 2 *", "2012-02-29 00:00"},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %s", test.spec, err)
+			continue
+		}
+		if got := cron.next(start).Format("2006-01-02 15:04"); got != test.next {
+			t.Errorf("%q: next = %s, want %s", test.spec, got, test.next)
+		}
+	}
+
+	for _, spec := range []string{"", "0", "60 *", "* 24", "5-1 *", "*/0 *", "1/5 *", "a *", "* * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestFileLogWriterCron(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "cron.log")
+
+	var mu sync.Mutex
+	clock := time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC)
+	now := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+
+	w := NewFileLogWriter(logfile, true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.setClock(now).SetFormat("%M").SetRotateCron("* * * * *")
+
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "same minute"))
+	w.Flush()
+
+	mu.Lock()
+	clock = clock.Add(time.Minute)
+	mu.Unlock()
+	w.LogWrite(newLogRecord(INFO, "source", "next minute"))
+	w.Close()
+
+	if contents, err := ioutil.ReadFile(logfile + ".001"); err != nil {
+		t.Errorf("Expected a rotated file: %s", err)
+	} else if string(contents) != "first\nsame minute\n" {
+		t.Errorf("Unexpected rotated log contents: %q", contents)
+	}
+	if contents, err := ioutil.ReadFile(logfile); err != nil {
+		t.Errorf("read(%q): %s", logfile, err)
+	} else if string(contents) != "next minute\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+}
+
+func TestFileLogWriterMaxAge(t *testing.T) {
+	const logfile = "_maxage.log"
+
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	// Old files which look like the log's but are not its own
+	others := []string{"my_maxage.log", logfile + ".lock", "_maxageXlog", logfile + ".bak.tar"}
+
+	cleanup := func() {
+		names, _ := filepath.Glob(logfile + "*")
+		for _, name := range append(names, others...) {
+			os.Remove(name)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	// One rotated file from long ago, and one from today
+	old, recent := logfile+".050", logfile+".051"
+	for _, name := range append([]string{old, recent}, others...) {
+		if err := ioutil.WriteFile(name, []byte("rotated\n"), 0644); err != nil {
+			t.Fatalf("write(%q): %s", name, err)
+		}
+	}
+	backdated := time.Now().Add(-10 * 24 * time.Hour)
+	for _, name := range append([]string{old}, others...) {
+		if err := os.Chtimes(name, backdated, backdated); err != nil {
+			t.Fatalf("chtimes(%q): %s", name, err)
+		}
+	}
+
+	w := NewFileLogWriter(logfile, true).SetRotateLines(1)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	w.maxAge = 7 * 24 * time.Hour
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be pruned by age", old)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("Expected %s to be kept: %s", recent, err)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("Expected %s, which is not a rotated log, to be kept: %s", name, err)
+		}
+	}
+
+	if d, err := parseMaxAge("7d"); err != nil || d != 7*24*time.Hour {
+		t.Errorf("parseMaxAge(7d) = %v, %v", d, err)
+	}
+	if d, err := parseMaxAge("24h"); err != nil || d != 24*time.Hour {
+		t.Errorf("parseMaxAge(24h) = %v, %v", d, err)
+	}
+	if _, err := parseMaxAge("sevend"); err == nil {
+		t.Errorf("parseMaxAge(sevend) should fail")
+	}
+}
+
+func TestFileLogWriterMaxTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, "total.log")
+
+	// Large files which look like the log's but are not its own
+	others := []string{filepath.Join(dir, "subtotal.log"), logfile + ".bak", logfile + ".001.tar"}
+	for _, name := range others {
+		if err := ioutil.WriteFile(name, bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+			t.Fatalf("write(%q): %s", name, err)
+		}
+	}
+
+	// Each file holds one 10 byte record
+	const limit = 35
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetRotateLines(1).SetMaxTotalSize(limit)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for i := 0; i < 10; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("record %d", i)))
+	}
+	w.Close()
+
+	// Just after rotating, the rotated files are all there is
+	names, _ := filepath.Glob(logfile + ".0[0-9][0-9]")
+	var total int64
+	var kept []string
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("stat(%q): %s", name, err)
+		}
+		total += info.Size()
+		contents, _ := ioutil.ReadFile(name)
+		kept = append(kept, strings.TrimSpace(string(contents)))
+	}
+	if total > limit {
+		t.Errorf("Expected the rotated files to total at most %d bytes, found %d in %v", limit, total, names)
+	}
+	if want := []string{"record 6", "record 7", "record 8"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("Expected the newest rotated files %v to be kept, found %v", want, kept)
+	}
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "record 9\n" {
+		t.Errorf("Unexpected active log contents: %q", contents)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("Expected %s, which is not a rotated log, to be kept: %s", filepath.Base(name), err)
+		}
+	}
+}
+
+func TestFileLogWriterHeaderTrailer(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "header.log")
+
+	w := NewFileLogWriter(logfile, true).SetFormat("%M").SetRotateLines(2).
+		SetHeader("# level message").SetTrailer("# end")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for _, msg := range []string{"first", "second", "third"} {
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	w.Close()
+
+	for name, want := range map[string]string{
+		logfile + ".001": "# level message\nfirst\nsecond\n# end\n",
+		logfile:          "# level message\nthird\n# end\n",
+	} {
+		if contents, _ := ioutil.ReadFile(name); string(contents) != want {
+			t.Errorf("Expected %s to contain %q, found %q", filepath.Base(name), want, contents)
+		}
+	}
+
+	// A header set twice is written once, and counts towards the size, so
+	// that the third record goes to a new file
+	logfile = filepath.Join(t.TempDir(), "sized.log")
+	w = NewFileLogWriter(logfile, true).SetFormat("%M").SetRotateSize(12).
+		SetHeadFoot("# old", "# end").SetHeader("# header")
+	for _, msg := range []string{"a", "b", "c"} {
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	w.Close()
+
+	for name, want := range map[string]string{
+		logfile + ".001": "# header\na\nb\n# end\n",
+		logfile:          "# header\nc\n# end\n",
+	} {
+		if contents, _ := ioutil.ReadFile(name); string(contents) != want {
+			t.Errorf("Expected %s to contain %q, found %q", filepath.Base(name), want, contents)
+		}
+	}
+}
+
+func TestBufferedFileLogWriter(t *testing.T) {
+	const (
+		logfile = "_buffered.log"
+		N       = 500
+	)
+
+	os.Remove(logfile)
+	defer os.Remove(logfile)
+
+	w := NewBufferedFileLogWriter(logfile, false, N).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	for i := 0; i < N; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("record %d", i)))
+	}
+	w.Close()
+
+	if dropped := w.Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped records, found %d", dropped)
+	}
+	contents, err := ioutil.ReadFile(logfile)
+	if err != nil {
+		t.Fatalf("read(%q): %s", logfile, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != N {
+		t.Fatalf("Expected Close to flush %d records, found %d", N, len(lines))
+	}
+	if last := lines[N-1]; last != fmt.Sprintf("record %d", N-1) {
+		t.Errorf("Unexpected last record %q", last)
+	}
+}
+
+func TestLoggerStats(t *testing.T) {
+	const logfile = "_stats.log"
+	os.Remove(logfile)
+	defer os.Remove(logfile)
+
+	w := NewBufferedFileLogWriter(logfile, false, 1).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	log := make(Logger)
+	log.AddFilter("file", FINEST, w)
+	log.AddFilter("memory", FINEST, NewMemoryLogWriter(10)) // not a StatsWriter
+	defer log.Close()
+
+	// Hold the writer's goroutine in a flush so the queue fills up
+	flushed := make(chan error)
+	w.fl <- flushed
+	for i := 0; i < 10; i++ {
+		log.Info("record %d", i)
+	}
+	<-flushed
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+
+	stats := log.Stats()
+	if _, ok := stats["memory"]; ok {
+		t.Errorf("Unexpected stats for a writer which does not count: %+v", stats["memory"])
+	}
+	if got, want := stats["file"], (WriterStats{Written: 1, Dropped: 9}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestMemoryLogWriter(t *testing.T) {
+	w := NewMemoryLogWriter(3).SetFormat("[%L] %M")
+
+	if lines := w.Dump(); len(lines) != 0 {
+		t.Errorf("Expected an empty dump, found %q", lines)
+	}
+
+	w.LogWrite(newLogRecord(INFO, "source", "one"))
+	w.LogWrite(newLogRecord(WARNING, "source", "two"))
+	if got, want := fmt.Sprint(w.Dump()), "[[INFO] one [WARN] two]"; got != want {
+		t.Errorf("Expected %s, found %s", want, got)
+	}
+
+	// Past capacity, the oldest records are dropped
+	for _, msg := range []string{"three", "four", "five"} {
+		w.LogWrite(newLogRecord(ERROR, "source", msg))
+	}
+	if got, want := fmt.Sprint(w.Dump()), "[[EROR] three [EROR] four [EROR] five]"; got != want {
+		t.Errorf("Expected %s, found %s", want, got)
+	}
+
+	w.Reset()
+	if lines := w.Dump(); len(lines) != 0 {
+		t.Errorf("Expected an empty dump after Reset, found %q", lines)
+	}
+}
+
+func TestMemoryLogWriterConcurrent(t *testing.T) {
+	const capacity, writers, records = 100, 8, 500
+
+	w := NewMemoryLogWriter(capacity).SetFormat("%M")
+	l := make(Logger).AddFilter("memory", FINEST, w)
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				l.Info("writer %d record %d", i, j)
+			}
+		}(i)
+	}
+
+	// Dump while the writers are busy
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			for _, line := range w.Dump() {
+				if !strings.HasPrefix(line, "writer ") {
+					t.Errorf("Unexpected line %q", line)
+					return
+				}
+			}
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	if lines := w.Dump(); len(lines) != capacity {
+		t.Errorf("Expected %d lines, found %d", capacity, len(lines))
+	}
+}
+
+func TestNullLogWriter(t *testing.T) {
+	var w LogWriter = NewNullLogWriter()
+	w.LogWrite(newLogRecord(CRITICAL, "source", "discarded"))
+	w.LogWrite(nil)
+	w.Close()
+
+	// A null filter keeps its tag, so it can be turned up later
+	l := make(Logger)
+	filt, err := propsToNullLogWriter("test", nil, true)
+	if err != nil {
+		t.Fatalf("propsToNullLogWriter: %s", err)
+	}
+	l.AddFilter("null", FINEST, filt)
+	l.Critical("discarded")
+	if _, ok := l.GetLevel("null"); !ok {
+		t.Errorf("Expected the null filter to be present")
+	}
+	l.Close()
+}
+
+type panickingWriter struct{}
+
+func (panickingWriter) LogWrite(rec *LogRecord) { panic("broken writer") }
+func (panickingWriter) Close()                  { panic("broken writer") }
+
+func TestMultiLogWriter(t *testing.T) {
+	first, second := NewMemoryLogWriter(10).SetFormat("%M"), NewMemoryLogWriter(10).SetFormat("%M")
+	w := NewMultiLogWriter(first, panickingWriter{}, second)
+
+	w.LogWrite(newLogRecord(INFO, "source", "fan out"))
+	w.Close()
+
+	for i, mw := range []*MemoryLogWriter{first, second} {
+		if got := fmt.Sprint(mw.Dump()); got != "[fan out]" {
+			t.Errorf("Expected writer %d to receive the record, found %s", i, got)
+		}
+	}
+
+	// A filter can nest the writers it sends to
+	const configfile = "_multi.xml"
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>both</tag>
+    <type>multi</type>
+    <level>INFO</level>
+    <filter>
+      <type>memory</type>
+      <property name="name">first</property>
+    </filter>
+    <filter enabled="false">
+      <type>memory</type>
+      <property name="name">disabled</property>
+    </filter>
+    <filter>
+      <type>memory</type>
+      <property name="name">second</property>
+    </filter>
+  </filter>
+</logging>`
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	defer os.Remove(configfile)
+
+	built := make(map[string]*MemoryLogWriter)
+	RegisterWriterType("memory", func(props map[string]string, enabled bool) (LogWriter, error) {
+		if !enabled {
+			return nil, nil
+		}
+		mw := NewMemoryLogWriter(10).SetFormat("%M")
+		built[props["name"]] = mw
+		return mw, nil
+	})
+	defer func() {
+		writerTypesMu.Lock()
+		delete(writerTypes, "memory")
+		writerTypesMu.Unlock()
+	}()
+
+	l := make(Logger)
+	if err := l.LoadConfiguration(configfile); err != nil {
+		t.Fatalf("LoadConfiguration: %s", err)
+	}
+	l.Info("to both")
+	l.Close()
+
+	if len(built) != 2 {
+		t.Fatalf("Expected two writers to be built, found %v", built)
+	}
+	for _, name := range []string{"first", "second"} {
+		if got := fmt.Sprint(built[name].Dump()); got != "[to both]" {
+			t.Errorf("Expected %s to receive the record, found %s", name, got)
+		}
+	}
+}
+
+func TestXMLLogWriter(t *testing.T) {
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	w := NewXMLLogWriter(testLogFile, false)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer os.Remove(testLogFile)
+
+	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+	w.Close()
+	runtime.Gosched()
+
+	if contents, err := ioutil.ReadFile(testLogFile); err != nil {
+		t.Errorf("read(%q): %s", testLogFile, err)
+	} else if len(string(contents)) != 185 {
+		t.Errorf("malformed xmllog: %q (expected: 185, actual: %d characters)", string(contents), len(string(contents)))
+	}
+}
+
+func TestXMLLogWriterTimestamp(t *testing.T) {
+	for format, want := range map[string]string{
+		XML_TIMESTAMP_DEFAULT:  "<timestamp>2009/02/13 23:31:30 UTC</timestamp>",
+		XML_TIMESTAMP_RFC3339:  "<timestamp>2009-02-13T23:31:30.123456789Z</timestamp>",
+		XML_TIMESTAMP_EPOCH_MS: "<timestamp>1234567890123</timestamp>",
+	} {
+		logfile := filepath.Join(t.TempDir(), "timestamp.xml")
+		w := NewXMLLogWriter(logfile, false).SetXMLTimestamp(format)
+		w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+		w.Close()
+
+		if contents, err := ioutil.ReadFile(logfile); err != nil {
+			t.Errorf("read(%q): %s", logfile, err)
+		} else if !strings.Contains(string(contents), want) {
+			t.Errorf("SetXMLTimestamp(%q): expected %q in %q", format, want, contents)
+		}
+	}
+
+	props := map[string]string{"filename": "timestamp.xml", "timestamp": "epoch"}
+	if _, err := propsToXMLLogWriter("timestamp.xml", props, false); err == nil {
+		t.Errorf("Expected an error for an unknown timestamp format")
+	}
+}
+
+func TestXMLLogWriterEscape(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "escape.xml")
+	w := NewXMLLogWriter(logfile, false)
+	rec := newLogRecord(CRITICAL, "<source>", "a < b && \"c\"\x00")
+	rec.Fields = map[string]interface{}{"key": "<value>"}
+	w.LogWrite(rec)
+	w.Close()
+
+	contents, err := ioutil.ReadFile(logfile)
+	if err != nil {
+		t.Fatalf("read(%q): %s", logfile, err)
+	}
+	var log struct {
+		Records []struct {
+			Level   string `xml:"level,attr"`
+			Source  string `xml:"source"`
+			Message string `xml:"message"`
+		} `xml:"record"`
+	}
+	if err := xml.Unmarshal(contents, &log); err != nil {
+		t.Fatalf("Invalid XML %q: %s", contents, err)
+	}
+	if len(log.Records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(log.Records))
+	}
+	if got, want := log.Records[0].Source, "<source>"; got != want {
+		t.Errorf("Source: got %q, want %q", got, want)
+	}
+	if got, want := log.Records[0].Message, "a < b && \"c\"\uFFFD key=<value>"; got != want {
+		t.Errorf("Message: got %q, want %q", got, want)
+	}
+}
+
+func TestJSONLogWriter(t *testing.T) {
+	defer func(buflen int) {
+		LogBufferLength = buflen
+	}(LogBufferLength)
+	LogBufferLength = 0
+
+	w := NewJSONLogWriter(testLogFile, false)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer os.Remove(testLogFile)
+
+	rec := newLogRecord(ERROR, "source", `say "hi"`)
+	rec.Fields = map[string]interface{}{"user": "bob", "n": 3}
+	w.LogWrite(rec)
+	w.LogWrite(newLogRecord(INFO, "source", "plain"))
+	w.Close()
+
+	contents, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		t.Fatalf("read(%q): %s", testLogFile, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), contents)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("unmarshal(%q): %s", lines[0], err)
+	}
+	want := map[string]interface{}{
+		"time":    "2009-02-13T23:31:30.123456789Z",
+		"level":   "ERROR",
+		"source":  "source",
+		"message": `say "hi"`,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if fields, ok := got["fields"].(map[string]interface{}); !ok {
+		t.Errorf("fields = %v, want an object", got["fields"])
+	} else if fields["user"] != "bob" || fields["n"] != 3.0 {
+		t.Errorf("fields = %v", fields)
+	}
+
+	got = nil
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("unmarshal(%q): %s", lines[1], err)
+	}
+	if _, ok := got["fields"]; ok {
+		t.Errorf("record without fields has fields: %q", lines[1])
+	}
+}
+
+// startCollector accepts JSON log records on ln, sending each message to
+// received.  The returned function closes the listener and every connection
+// it accepted.
+func startCollector(ln net.Listener, received chan<- string) (stop func()) {
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func(conn net.Conn) {
+				dec := json.NewDecoder(conn)
+				for {
+					var rec LogRecord
+					if err := dec.Decode(&rec); err != nil {
+						return
+					}
+					received <- rec.Message
+				}
+			}(conn)
+		}
+	}()
+	return func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestSocketLogWriterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	addr := ln.Addr().String()
+
+	received := make(chan string, 100)
+	stop := startCollector(ln, received)
+
+	w := NewSocketLogWriter("tcp", addr).SetReconnect(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer w.Close()
+
+	w.LogWrite(newLogRecord(INFO, "source", "before"))
+	select {
+	case msg := <-received:
+		if msg != "before" {
+			t.Fatalf("Expected %q, got %q", "before", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for first record")
+	}
+
+	// Take the collector down, killing the established connection
+	stop()
+
+	// Log while it is down; these are lost or buffered
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("down %d", i)))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Bring it back and keep logging until records flow again
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("relisten: %s", err)
+	}
+	defer startCollector(ln, received)()
+
+	deadline := time.After(10 * time.Second)
+	for i := 0; ; i++ {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out waiting for records after reconnect")
+		case msg := <-received:
+			if strings.HasPrefix(msg, "after") {
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+			w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("after %d", i)))
+		}
+	}
+}
+
+func TestSocketLogWriterDeferredDial(t *testing.T) {
+	// Find a free port, leaving nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	w := NewSocketLogWriter("tcp", addr)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "early"))
+	time.Sleep(20 * time.Millisecond)
+
+	// Start the collector and keep logging until the records flow, the one
+	// logged before it was up first
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("relisten: %s", err)
+	}
+	received := make(chan string, 100)
+	defer startCollector(ln, received)()
+
+	deadline := time.After(10 * time.Second)
+	for i := 0; ; i++ {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out waiting for records once the collector was up")
+		case msg := <-received:
+			if msg != "early" {
+				t.Fatalf("Expected %q first, got %q", "early", msg)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+			w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("later %d", i)))
+		}
+	}
+}
+
+func TestLoggerUnhealthy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	received := make(chan string, 100)
+	stop := startCollector(ln, received)
+
+	w := NewSocketLogWriter("tcp", addr).SetReconnect(true)
+	log := make(Logger).AddFilter("socket", INFO, w).AddFilter("memory", INFO, NewMemoryLogWriter(10))
+	defer log.Close()
+
+	// Keeps logging until the socket writer reports health as wanted
+	waitHealthy := func(want bool) {
+		deadline := time.After(10 * time.Second)
+		for i := 0; w.Healthy() != want; i++ {
+			select {
+			case <-deadline:
+				t.Fatalf("Timed out waiting for Healthy() = %v", want)
+			case <-received:
+			case <-time.After(20 * time.Millisecond):
+				log.Info("record %d", i)
+			}
+		}
+	}
+
+	if got := log.Unhealthy(); len(got) != 0 {
+		t.Errorf("Expected no unhealthy writers while connected, found %q", got)
+	}
+
+	// Take the collector down; the writer notices when it next writes
+	stop()
+	waitHealthy(false)
+	if got := log.Unhealthy(); !reflect.DeepEqual(got, []string{"socket"}) {
+		t.Errorf("Expected the socket writer to be unhealthy, found %q", got)
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("relisten: %s", err)
+	}
+	defer startCollector(ln, received)()
+	waitHealthy(true)
+	if got := log.Unhealthy(); len(got) != 0 {
+		t.Errorf("Expected no unhealthy writers after reconnecting, found %q", got)
+	}
+}
+
+func TestSocketLogWriterBatch(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	w := NewSocketLogWriter("udp", conn.LocalAddr().String()).SetBatch(64*1024, time.Hour)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	if w.batchBytes != maxUDPBatch {
+		t.Errorf("Expected the batch size to be capped at %d for UDP, got %d", maxUDPBatch, w.batchBytes)
+	}
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("record %d", i)))
+	}
+	w.Close() // sends the final batch
+
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 records in one datagram, got %q", buf[:n])
+	}
+	for i, line := range lines {
+		var rec LogRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Invalid record %q: %s", line, err)
+		}
+		if want := fmt.Sprintf("record %d", i); rec.Message != want {
+			t.Errorf("Record %d: got %q, want %q", i, rec.Message, want)
+		}
+	}
+	if got := w.Stats().Written; got != 3 {
+		t.Errorf("Expected 3 records written, got %d", got)
+	}
+}
+
+func TestSocketLogWriterOverflow(t *testing.T) {
+	for _, test := range []struct {
+		policy OverflowPolicy
+		want   []string
+	}{
+		{Block, []string{"0", "1", "2", "3"}},
+		{DropNewest, []string{"0", "1", "2"}},
+		{DropOldest, []string{"0", "2", "3"}},
+	} {
+		// Nothing reads from the pipe yet, so the writer stalls on the first record
+		server, client := net.Pipe()
+		w, err := newSocketLogWriter("pipe", "pipe", func() (net.Conn, error) { return client, nil })
+		if err != nil {
+			t.Fatalf("newSocketLogWriter: %s", err)
+		}
+		w.SetQueueSize(2).SetOverflow(test.policy)
+
+		w.LogWrite(newLogRecord(INFO, "source", "0"))
+		for len(w.rec) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.LogWrite(newLogRecord(INFO, "source", "1"))
+		w.LogWrite(newLogRecord(INFO, "source", "2"))
+
+		logged := make(chan bool)
+		go func() {
+			w.LogWrite(newLogRecord(INFO, "source", "3"))
+			close(logged)
+		}()
+		select {
+		case <-logged:
+			if test.policy == Block {
+				t.Errorf("Block: LogWrite returned with the queue full")
+			}
+		case <-time.After(50 * time.Millisecond):
+			if test.policy != Block {
+				t.Errorf("Policy %d: LogWrite blocked with the queue full", test.policy)
+			}
+		}
+
+		// Unstall the writer and collect what it sends
+		var got []string
+		dec := json.NewDecoder(server)
+		for range test.want {
+			var rec LogRecord
+			if err := dec.Decode(&rec); err != nil {
+				t.Fatalf("Policy %d: decode: %s", test.policy, err)
+			}
+			got = append(got, rec.Message)
+		}
+		<-logged
+		w.Close()
+		server.Close()
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Policy %d: got %q, want %q", test.policy, got, test.want)
+		}
+		if wantDropped := int64(4 - len(test.want)); w.Dropped() != wantDropped {
+			t.Errorf("Policy %d: dropped %d, want %d", test.policy, w.Dropped(), wantDropped)
+		}
+	}
+}
+
+func TestSocketConfigQueueSize(t *testing.T) {
+	for _, value := range []string{"1x", "lots", "-1"} {
+		props := map[string]string{"endpoint": "127.0.0.1:12124", "protocol": "udp", "queuesize": value}
+		if _, err := propsToSocketLogWriter("queue.xml", props, false); err == nil {
+			t.Errorf("Expected queuesize %q to produce an error", value)
+		}
+	}
+
+	props := map[string]string{"endpoint": "127.0.0.1:12124", "protocol": "udp", "queuesize": "2K"}
+	w, err := propsToSocketLogWriter("queue.xml", props, true)
+	if err != nil {
+		t.Fatalf("propsToSocketLogWriter: %s", err)
+	}
+	defer w.Close()
+	if got := cap(w.rec); got != 2000 {
+		t.Errorf("Expected a queue of 2000 records, found %d", got)
+	}
+}
+
+func TestSocketLogWriterJSON(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	w := NewSocketLogWriter("tcp", ln.Addr().String()).SetJSON(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		rec := newLogRecord(WARNING, "source", fmt.Sprintf("record %d", i))
+		rec.Fields = map[string]interface{}{"n": i}
+		w.LogWrite(rec)
+	}
+	w.Close()
+
+	scanner := bufio.NewScanner(conn)
+	var lines int
+	for ; scanner.Scan(); lines++ {
+		var rec struct {
+			Level   string `json:"level"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid JSON line %q: %s", scanner.Text(), err)
+		}
+		if want := fmt.Sprintf("record %d", lines); rec.Level != "WARNING" || rec.Message != want {
+			t.Errorf("Line %d: got %+v, want level WARNING and message %q", lines, rec, want)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 lines, got %d", lines)
+	}
+}
+
+func TestSocketLogWriterUnencodableFields(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	w := NewSocketLogWriter("tcp", ln.Addr().String())
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+	defer conn.Close()
+
+	rec := newLogRecord(WARNING, "source", "bad fields")
+	rec.Fields = map[string]interface{}{"ratio": math.NaN(), "n": 1}
+	w.LogWrite(rec)
+	w.LogWrite(newLogRecord(WARNING, "source", "after"))
+	w.Close()
+
+	var got []LogRecord
+	dec := json.NewDecoder(conn)
+	for {
+		var rec LogRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 2 || got[0].Message != "bad fields" || got[1].Message != "after" {
+		t.Fatalf("Expected both records to be sent, got %+v", got)
+	}
+	if ratio, n := got[0].Fields["ratio"], got[0].Fields["n"]; ratio != "NaN" || n != 1.0 {
+		t.Errorf("Expected ratio \"NaN\" and n 1, got %v and %v", ratio, n)
+	}
+	if s := w.Stats(); s.Errors != 0 {
+		t.Errorf("Expected no errors, got %+v", s)
+	}
+}
+
+func TestUnixSocketLogWriter(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("unixgram sockets are not supported on " + runtime.GOOS)
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "log.sock")
+
+	listen := func() net.PacketConn {
+		conn, err := net.ListenPacket("unixgram", addr)
+		if err != nil {
+			t.Fatalf("listen: %s", err)
+		}
+		return conn
+	}
+	receive := func(conn net.PacketConn, timeout time.Duration) (string, error) {
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", err
+		}
+		var rec LogRecord
+		if err := json.Unmarshal(buf[:n], &rec); err != nil {
+			return "", err
+		}
+		return rec.Message, nil
+	}
+
+	conn := listen()
+	w := NewSocketLogWriter("unixgram", addr).SetReconnect(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer w.Close()
+
+	w.LogWrite(newLogRecord(INFO, "source", "before"))
+	if msg, err := receive(conn, 5*time.Second); err != nil || msg != "before" {
+		t.Fatalf("Expected %q, got %q (%v)", "before", msg, err)
+	}
+
+	// Remove the socket file out from under the writer
+	conn.Close()
+	os.Remove(addr)
+	w.LogWrite(newLogRecord(INFO, "source", "down"))
+	time.Sleep(20 * time.Millisecond)
+
+	// Bring it back and keep logging until records flow again
+	conn = listen()
+	defer conn.Close()
+	for i := 0; i < 200; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("after %d", i)))
+		if msg, err := receive(conn, 50*time.Millisecond); err == nil && msg != "before" {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for records after the socket came back")
+}
+
+func TestTLSSocketLogWriter(t *testing.T) {
+	// Borrow the in-memory certificate from an httptest TLS server
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	received := make(chan string, 1)
+	defer startCollector(ln, received)()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// The handshake fails without the right roots
+	if w, err := NewTLSSocketLogWriter("tcp", ln.Addr().String(), &tls.Config{RootCAs: x509.NewCertPool()}); err == nil {
+		w.Close()
+		t.Errorf("Expected handshake with an untrusted certificate to fail")
+	}
+
+	w, err := NewTLSSocketLogWriter("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("NewTLSSocketLogWriter: %s", err)
+	}
+	defer w.Close()
+
+	w.LogWrite(newLogRecord(INFO, "source", "encrypted"))
+	select {
+	case msg := <-received:
+		if msg != "encrypted" {
+			t.Errorf("Expected %q, got %q", "encrypted", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for record")
+	}
+
+	// Unreadable certificate files are reported as configuration errors
+	props := map[string]string{"endpoint": ln.Addr().String(), "protocol": "tls", "cafile": "_missing_ca.pem"}
+	if _, err := propsToSocketLogWriter("tls.xml", props, true); err == nil {
+		t.Errorf("Expected missing CA file to produce an error")
+	}
+}
+
+func TestHTTPLogWriter(t *testing.T) {
+	defer func(d time.Duration) { httpRetryBackoff = d }(httpRetryBackoff)
+	httpRetryBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	var batches [][]string
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if got := req.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Expected the auth header, found %q", got)
+		}
+		if failures > 0 {
+			failures--
+			http.Error(rw, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var recs []LogRecord
+		if err := json.NewDecoder(req.Body).Decode(&recs); err != nil {
+			t.Errorf("decode: %s", err)
+		}
+		var msgs []string
+		for _, rec := range recs {
+			msgs = append(msgs, rec.Message)
+		}
+		batches = append(batches, msgs)
+	}))
+	defer srv.Close()
+
+	props := map[string]string{
+		"url":                  srv.URL,
+		"batchsize":            "3",
+		"flushinterval":        "1h",
+		"header.Authorization": "Bearer token",
+	}
+	w, err := propsToHTTPLogWriter("test", props, true)
+	if err != nil {
+		t.Fatalf("propsToHTTPLogWriter: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprint(i)))
+	}
+
+	// The first batch is sent as soon as it is full, after a retry
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(batches)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the first batch")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// And the rest on Close
+	w.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(batches); got != "[[0 1 2] [3 4]]" {
+		t.Errorf("Expected batches [[0 1 2] [3 4]], found %s", got)
+	}
+}
+
+func TestHTTPLogWriterUnencodableFields(t *testing.T) {
+	msgs := make(chan []string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var recs []LogRecord
+		if err := json.NewDecoder(req.Body).Decode(&recs); err != nil {
+			t.Errorf("decode: %s", err)
+		}
+		var got []string
+		for _, rec := range recs {
+			got = append(got, fmt.Sprint(rec.Message, rec.Fields))
+		}
+		msgs <- got
+	}))
+	defer srv.Close()
+
+	w, err := propsToHTTPLogWriter("test", map[string]string{"url": srv.URL, "flushinterval": "1h"}, true)
+	if err != nil {
+		t.Fatalf("propsToHTTPLogWriter: %s", err)
+	}
+	rec := newLogRecord(INFO, "source", "bad")
+	rec.Fields = map[string]interface{}{"ch": make(chan int)}
+	w.LogWrite(rec)
+	w.LogWrite(newLogRecord(INFO, "source", "good"))
+	w.Close()
+
+	var got []string
+	select {
+	case got = <-msgs:
+	default:
+		t.Fatal("Expected the batch to be sent on Close")
+	}
+	if len(got) != 2 || !strings.HasPrefix(got[0], "badmap[ch:0x") || got[1] != "goodmap[]" {
+		t.Errorf("Expected the whole batch with the channel as a string, got %q", got)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	sl := NewDefaultLogger(WARNING)
+	if sl == nil {
+		t.Fatalf("NewDefaultLogger should never return nil")
+	}
+	if lw, exist := sl["stdout"]; lw == nil || exist != true {
+		t.Fatalf("NewDefaultLogger produced invalid logger (DNE or nil)")
+	}
+	if sl["stdout"].Level != WARNING {
+		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect level)")
+	}
+	if len(sl) != 1 {
+		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect map count)")
+	}
+
+	//func (l *Logger) AddFilter(name string, level int, writer LogWriter) {}
+	l := make(Logger)
+	l.AddFilter("stdout", DEBUG, NewConsoleLogWriter())
+	if lw, exist := l["stdout"]; lw == nil || exist != true {
+		t.Fatalf("AddFilter produced invalid logger (DNE or nil)")
+	}
+	if l["stdout"].Level != DEBUG {
+		t.Fatalf("AddFilter produced invalid logger (incorrect level)")
+	}
+	if len(l) != 1 {
+		t.Fatalf("AddFilter produced invalid logger (incorrect map count)")
+	}
+
+	//func (l *Logger) Warn(format string, args ...interface{}) error {}
+	if err := l.Warn("%s %d %#v", "Warning:", 1, []int{}); err.Error() != "Warning: 1 []int{}" {
+		t.Errorf("Warn returned invalid error: %s", err)
+	}
+
+	//func (l *Logger) Error(format string, args ...interface{}) error {}
+	if err := l.Error("%s %d %#v", "Error:", 10, []string{}); err.Error() != "Error: 10 []string{}" {
+		t.Errorf("Error returned invalid error: %s", err)
+	}
+
+	//func (l *Logger) Critical(format string, args ...interface{}) error {}
+	if err := l.Critical("%s %d %#v", "Critical:", 100, []int64{}); err.Error() != "Critical: 100 []int64{}" {
+		t.Errorf("Critical returned invalid error: %s", err)
+	}
+
+	// Already tested or basically untestable
+	//func (l *Logger) Log(level int, source, message string) {}
+	//func (l *Logger) Logf(level int, format string, args ...interface{}) {}
+	//func (l *Logger) intLogf(level int, format string, args ...interface{}) string {}
+	//func (l *Logger) Finest(format string, args ...interface{}) {}
+	//func (l *Logger) Fine(format string, args ...interface{}) {}
+	//func (l *Logger) Debug(format string, args ...interface{}) {}
+	//func (l *Logger) Trace(format string, args ...interface{}) {}
+	//func (l *Logger) Info(format string, args ...interface{}) {}
+}
+
+// recordingWriter is a synchronous LogWriter which keeps every record it is
+// given, for inspection by tests.
+type recordingWriter struct {
+	mu      sync.Mutex
+	records []*LogRecord
+	closed  bool
+}
+
+func (w *recordingWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records = append(w.records, rec)
+}
+
+func (w *recordingWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+}
+
+func (w *recordingWriter) messages() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msgs := make([]string, 0, len(w.records))
+	for _, rec := range w.records {
+		msgs = append(msgs, rec.Message)
+	}
+	return msgs
+}
+
+func TestMaxMessageLen(t *testing.T) {
+	rw := new(recordingWriter)
+	log := make(Logger)
+	defer log.Close()
+	log.AddFilter("rec", FINEST, rw)
+	log.SetMaxMessageLen(100)
+
+	log.Info(strings.Repeat("x", 10*1024))
+	log.Info(strings.Repeat("\u00e9", 100)) // two bytes each
+	log.Info("short")
+
+	msgs := rw.messages()
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(msgs))
+	}
+	if got := msgs[0]; len(got) != 100 || !strings.HasSuffix(got, "\u2026[truncated]") {
+		t.Errorf("Expected 100 bytes ending in the marker, got %d bytes: %q", len(got), got)
+	}
+	if got := msgs[1]; !utf8.ValidString(got) || len(got) > 100 || !strings.HasSuffix(got, "\u2026[truncated]") {
+		t.Errorf("Expected valid UTF-8 of at most 100 bytes ending in the marker, got %q", got)
+	}
+	if got := msgs[2]; got != "short" {
+		t.Errorf("Expected short message to be untouched, got %q", got)
+	}
+
+	// A filter's own limit takes precedence
+	log["rec"].SetMaxMessageLen(10)
+	log.Info(strings.Repeat("x", 50))
+	if got := rw.messages()[3]; got != "xxxxxxxxxx" {
+		t.Errorf("Expected filter limit without room for the marker, got %q", got)
+	}
+}
+
+func TestAddRedactor(t *testing.T) {
+	rw := new(recordingWriter)
+	log := make(Logger)
+	defer log.Close()
+	log.AddFilter("rec", FINEST, rw)
+	log.AddFilter("rec2", FINEST, new(recordingWriter))
+	log.AddRedactor(regexp.MustCompile(`\b(?:\d[ -]?){12}(\d{4})\b`), "****-****-****-$1")
+	log.AddRedactor(regexp.MustCompile(`\*{4}-\*{4}-\*{4}-`), "[card ending] ")
+
+	log.Info("charging 4111 1111 1111 1234 for order %d", 42)
+
+	if got, want := rw.messages(), []string{"charging [card ending] 1234 for order 42"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigurationKeepsOptions(t *testing.T) {
+	log := make(Logger)
+	log.SetCallerSkip(1)
+	log.SetSourceCapture(false)
+	log.SetHook(func(*LogRecord) {})
+	log.SetUTC(true)
+	log.SetMaxMessageLen(5)
+	log.SetGlobalMinLevel(DEBUG)
+
+	conf := `<logging><filter enabled="true"><tag>null</tag><type>null</type><level>INFO</level></filter></logging>`
+	if err := log.LoadConfigurationFromReader(strings.NewReader(conf), "options.xml"); err != nil {
+		t.Fatalf("LoadConfigurationFromReader: %s", err)
+	}
+	opts := log.options()
+	if opts == nil || opts.callerSkip != 1 || !opts.noSource || len(opts.hooks) != 1 || !opts.utc ||
+		opts.maxMessageLen != 5 || !opts.floorSet || opts.floor != DEBUG {
+		t.Errorf("Expected loading a configuration to keep the logger's settings, found %+v", opts)
+	}
+
+	log.Close()
+	if opts := log.options(); opts != nil {
+		t.Errorf("Expected Close to restore the default settings, found %+v", opts)
+	}
+}
+
+func TestAddRedactorBeforeLoad(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "redacted.log")
+	log := make(Logger)
+	log.AddRedactor(regexp.MustCompile(`secret`), "[redacted]")
+
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>INFO</level>
+    <property name="filename">` + logfile + `</property>
+    <property name="format">%M</property>
+  </filter>
+</logging>`
+	if err := log.LoadConfigurationFromReader(strings.NewReader(conf), "redact.xml"); err != nil {
+		t.Fatalf("LoadConfigurationFromReader: %s", err)
+	}
+	log.Info("the secret is here")
+	log.Close()
+
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "the [redacted] is here\n" {
+		t.Errorf("Expected the message to be redacted, found %q", contents)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	dir := t.TempDir()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	writers := map[string]LogWriter{
+		"file":     NewFileLogWriter(filepath.Join(dir, "file.log"), true),
+		"buffered": NewBufferedFileLogWriter(filepath.Join(dir, "buffered.log"), false, 10),
+		"xml":      NewXMLLogWriter(filepath.Join(dir, "file.xml"), false),
+		"json":     NewJSONLogWriter(filepath.Join(dir, "file.json"), false),
+		"console":  NewConsoleLogWriterStream(ioutil.Discard, ioutil.Discard),
+		"format":   NewFormatLogWriter(ioutil.Discard, FORMAT_DEFAULT),
+		"socket":   NewSocketLogWriter("udp", conn.LocalAddr().String()),
+		"http":     NewHTTPLogWriter(server.URL, 10, time.Second),
+		"memory":   NewMemoryLogWriter(10),
+		"null":     NewNullLogWriter(),
+		"multi":    NewMultiLogWriter(NewNullLogWriter(), NewFileLogWriter(filepath.Join(dir, "multi.log"), false)),
+	}
+
+	log := make(Logger)
+	for name, w := range writers {
+		log.AddFilter(name, FINEST, w)
+	}
+	log.Info("message")
+	log.Close()
+	log.Close()
+	if err := log.CloseContext(context.Background()); err != nil {
+		t.Errorf("CloseContext after Close: %s", err)
+	}
+
+	for name, w := range writers {
+		if err := safely(w.Close); err != nil {
+			t.Errorf("%s: second Close: %s", name, err)
+		}
+	}
+}
+
+func TestSetMessageFilter(t *testing.T) {
+	rw := new(recordingWriter)
+	log := make(Logger)
+	defer log.Close()
+	log.AddFilter("rec", FINEST, rw)
+	log["rec"].SetMessageFilter(nil, regexp.MustCompile(`healthz`))
+
+	log.Info("GET /healthz 200")
+	log.Info("GET /users 200")
+	log.Info("GET /healthz 200")
+	log.Warn("POST /users 500")
+	if got, want := rw.messages(), []string{"GET /users 200", "POST /users 500"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Excluding: got %q, want %q", got, want)
+	}
+
+	log["rec"].SetMessageFilter(regexp.MustCompile(`^POST `), regexp.MustCompile(`healthz`))
+	log.Info("POST /healthz 200")
+	log.Info("GET /users 200")
+	log.Info("POST /orders 201")
+	if got, want := rw.messages()[2:], []string{"POST /orders 201"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Including: got %q, want %q", got, want)
+	}
+}
+
+func TestFilterUnkeyedLiteral(t *testing.T) {
+	rw := new(recordingWriter)
+	log := Logger{"rec": &Filter{INFO, rw}}
+	defer log.Close()
+	log["rec"].SetSampleRate(2)
+
+	for i := 0; i < 4; i++ {
+		log.Info("record %d", i)
+	}
+	log.Debug("too low")
+	if got, want := rw.messages(), []string{"record 0", "record 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Messages: got %q, want %q", got, want)
+	}
+}
+
+func TestSetLevelWildcard(t *testing.T) {
+	log := make(Logger)
+	defer log.Close()
+	for _, tag := range []string{"http.server", "http.client", "http.server.tls", "https", "db"} {
+		log.AddFilter(tag, INFO, NewNullLogWriter())
+	}
+
+	if err := log.SetLevel("http.*", WARNING); err != nil {
+		t.Fatalf("SetLevel: %s", err)
+	}
+	for tag, want := range map[string]Level{
+		"http.server":     WARNING,
+		"http.client":     WARNING,
+		"http.server.tls": WARNING,
+		"https":           INFO,
+		"db":              INFO,
+	} {
+		if got, _ := log.GetLevel(tag); got != want {
+			t.Errorf("%s: got level %s, want %s", tag, got, want)
+		}
+	}
+
+	if err := log.SetLevel("db", ERROR); err != nil {
+		t.Errorf("SetLevel exact tag: %s", err)
+	}
+	if err := log.SetLevel("grpc.*", ERROR); err == nil {
+		t.Errorf("Expected an error for a pattern matching no filters")
+	}
+	if err := log.SetLevel("http.[", ERROR); err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
+
+func TestAddRemoveFilter(t *testing.T) {
+	first, second := new(recordingWriter), new(recordingWriter)
+	log := make(Logger)
+	defer log.Close()
+
+	log.AddFilter("rec", INFO, first).AddFilter("null", INFO, NewNullLogWriter())
+	log.Info("first")
+	if got, want := first.messages(), []string{"first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Added: got %q, want %q", got, want)
+	}
+
+	// Replacing the filter closes the old writer, but not a writer added again
+	log.AddFilter("rec", INFO, second)
+	if !first.closed {
+		t.Errorf("Expected the replaced writer to be closed")
+	}
+	log.AddFilter("rec", WARNING, second)
+	if second.closed {
+		t.Errorf("Expected a writer added again not to be closed")
+	}
+	log.Warn("second")
+	if got, want := second.messages(), []string{"second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replaced: got %q, want %q", got, want)
+	}
+
+	log.RemoveFilter("rec")
+	log.RemoveFilter("missing")
+	if !second.closed {
+		t.Errorf("Expected the removed writer to be closed")
+	}
+	if _, ok := log["rec"]; ok {
+		t.Errorf("Expected the filter to be removed")
+	}
+	if len(log) != 1 {
+		t.Errorf("Expected 1 filter left, got %d", len(log))
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dir := t.TempDir()
+	config := `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>DEBUG</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">` + filepath.Join(dir, "test.log") + `</property>
+  </filter>
+  <filter enabled="true">
+    <tag>xmllog</tag>
+    <type>xml</type>
+    <level>TRACE</level>
+    <property name="filename">` + filepath.Join(dir, "trace.xml") + `</property>
+  </filter>
+</logging>`
+
+	log := make(Logger)
+	if err := log.LoadConfigurationFromReader(strings.NewReader(config), "filters.xml"); err != nil {
+		t.Fatalf("LoadConfigurationFromReader: %s", err)
+	}
+	log.AddFilter("custom", ERROR, new(recordingWriter))
+	defer log.Close()
+
+	want := []FilterInfo{
+		{"custom", ERROR, "*log4go.recordingWriter"},
+		{"file", FINEST, "file"},
+		{"stdout", DEBUG, "console"},
+		{"xmllog", TRACE, "xml"},
+	}
+	if got := log.Filters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// stackError carries a stack trace like the errors of github.com/pkg/errors
+type stackError struct {
+	msg   string
+	stack []string
+}
+
+func (e *stackError) Error() string        { return e.msg }
+func (e *stackError) StackTrace() []string { return e.stack }
+
+// verboseError prints more detail with %+v
+type verboseError struct{}
+
+func (verboseError) Error() string { return "verbose" }
+func (e verboseError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		io.WriteString(s, "verbose\n\tdetail")
+		return
+	}
+	io.WriteString(s, e.Error())
+}
+
+func TestLogError(t *testing.T) {
+	var recs []*LogRecord
+	log := make(Logger)
+	log.AddFilter("memory", FINEST, NewMemoryLogWriter(10))
+	log.SetHook(func(r *LogRecord) { recs = append(recs, r) })
+	defer log.Close()
+
+	inner := &stackError{msg: "disk full", stack: []string{"main.save", "main.main"}}
+	log.LogError(ERROR, fmt.Errorf("saving %s: %w", "config", inner), "save failed: 100% of %v")
+	log.LogError(WARNING, verboseError{}, "verbose")
+	log.LogError(INFO, errors.New("plain"), "plain")
+	log.LogError(INFO, nil, "no error")
+
+	if len(recs) != 4 {
+		t.Fatalf("Expected 4 records, found %d", len(recs))
+	}
+	if got, want := recs[0].Message, "save failed: 100% of %v"; got != want {
+		t.Errorf("Message = %q, want %q", got, want)
+	}
+	want := map[string]interface{}{"error": "saving config: disk full", "stack": "[main.save main.main]"}
+	if !reflect.DeepEqual(recs[0].Fields, want) {
+		t.Errorf("Wrapped error fields = %v, want %v", recs[0].Fields, want)
+	}
+	want = map[string]interface{}{"error": "verbose", "stack": "verbose\n\tdetail"}
+	if !reflect.DeepEqual(recs[1].Fields, want) {
+		t.Errorf("Verbose error fields = %v, want %v", recs[1].Fields, want)
+	}
+	want = map[string]interface{}{"error": "plain"}
+	if !reflect.DeepEqual(recs[2].Fields, want) {
+		t.Errorf("Plain error fields = %v, want %v", recs[2].Fields, want)
+	}
+	if recs[3].Fields != nil {
+		t.Errorf("Nil error fields = %v, want none", recs[3].Fields)
+	}
+}
+
+func TestSetUTC(t *testing.T) {
+	// A fixed instant, logged in a zone west of UTC
+	est := time.FixedZone("EST", -5*60*60)
+	instant := time.Date(2009, time.February, 13, 18, 31, 30, 0, est)
+	rec := &LogRecord{Level: INFO, Created: instant, Message: "message"}
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		set  func(w *FileLogWriter)
+		want string
+	}{
+		{"asis", func(w *FileLogWriter) {}, "2009/02/13 18:31:30 -0500 message\n"},
+		{"utc", func(w *FileLogWriter) { w.SetUTC(true) }, "2009/02/13 23:31:30 +0000 message\n"},
+		{"local", func(w *FileLogWriter) { w.SetUTC(false) }, instant.Local().Format("2006/01/02 15:04:05 -0700") + " message\n"},
+	} {
+		logfile := filepath.Join(dir, tc.name+".log")
+		w := NewFileLogWriter(logfile, false).SetFormat("%D %T %z %M").SetTimeFormat("15:04:05")
+		tc.set(w)
+		w.LogWrite(rec)
+		w.Close()
+		if contents, _ := ioutil.ReadFile(logfile); string(contents) != tc.want {
+			t.Errorf("SetUTC %s: Expected %q, found %q", tc.name, tc.want, contents)
+		}
+	}
+
+	// The logger converts the time of the records it dispatches
+	var created []time.Time
+	log := make(Logger)
+	log.AddFilter("null", FINEST, NewNullLogWriter())
+	log.SetHook(func(r *LogRecord) { created = append(created, r.Created) })
+	log.Info("local")
+	log.SetUTC(true)
+	log.Info("utc")
+	log.Close()
+	if len(created) != 2 || created[0].Location() != time.Local || created[1].Location() != time.UTC {
+		t.Errorf("SetUTC: Expected a local then a UTC time, found %v", created)
+	}
+}
+
+// failingWriter accepts records but fails to flush them
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) LogWrite(rec *LogRecord) {}
+func (w failingWriter) Close()                  {}
+func (w failingWriter) Flush() error            { return w.err }
+
+func TestLogChecked(t *testing.T) {
+	errFull := errors.New("disk full")
+	log := make(Logger)
+	log.AddFilter("ok", DEBUG, NewMemoryLogWriter(10))
+	log.AddFilter("failing", ERROR, failingWriter{errFull})
+	defer log.Close()
+
+	if err := log.LogChecked(INFO, "fine %d", 1); err != nil {
+		t.Errorf("LogChecked(INFO) = %v, want nil", err)
+	}
+	if err := log.LogChecked(ERROR, "broken %d", 2); err != errFull {
+		t.Errorf("LogChecked(ERROR) = %v, want %v", err, errFull)
+	}
+	if err := log.LogChecked(FINEST, "skipped"); err != nil {
+		t.Errorf("LogChecked(FINEST) = %v, want nil", err)
+	}
+
+	// The default methods are unchanged
+	log.Error("unchecked")
+}
+
+// panicOnWrite counts its writes, panicking on each
+type panicOnWrite struct {
+	writes int32
+}
+
+func (w *panicOnWrite) LogWrite(rec *LogRecord) {
+	atomic.AddInt32(&w.writes, 1)
+	panic("broken writer")
+}
+func (w *panicOnWrite) Close() { panic("broken writer") }
+
+func TestDispatchRecoversPanic(t *testing.T) {
+	broken := &panicOnWrite{}
+	mem := NewMemoryLogWriter(10).SetFormat("%M")
+	log := make(Logger)
+	log.AddFilter("broken", FINEST, broken)
+	log.AddFilter("memory", FINEST, mem)
+
+	log.Info("first")
+	if err := log.LogChecked(INFO, "second"); err != nil {
+		t.Errorf("LogChecked after the writer was disabled = %v, want nil", err)
+	}
+	log.Close()
+
+	if got := atomic.LoadInt32(&broken.writes); got != 1 {
+		t.Errorf("Panicking writer was given %d records, want 1 before it was disabled", got)
+	}
+	if got := fmt.Sprint(mem.Dump()); got != "[first second]" {
+		t.Errorf("Other writer received %s, want [first second]", got)
+	}
+
+	// The panic is returned to LogChecked
+	log = make(Logger)
+	log.AddFilter("broken", FINEST, &panicOnWrite{})
+	if err := log.LogChecked(INFO, "checked"); err == nil || !strings.Contains(err.Error(), "broken writer") {
+		t.Errorf("LogChecked = %v, want the panic", err)
+	}
+	log.Close()
+}
+
+// slowCloseWriter takes the given time to close
+type slowCloseWriter time.Duration
+
+func (w slowCloseWriter) LogWrite(rec *LogRecord) {}
+func (w slowCloseWriter) Close()                  { time.Sleep(time.Duration(w)) }
+
+func TestCloseContext(t *testing.T) {
+	log := make(Logger)
+	log.AddFilter("fast", FINEST, NewNullLogWriter())
+	log.AddFilter("slow", FINEST, slowCloseWriter(time.Second))
+	log.AddFilter("broken", FINEST, panickingWriter{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := log.CloseContext(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("CloseContext took %s, expected it to give up at the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseContext = %v, want a context.DeadlineExceeded error", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), `filter "slow": close timed out`) ||
+		!strings.Contains(err.Error(), `filter "broken": panic`) || strings.Contains(err.Error(), "fast") {
+		t.Errorf("CloseContext = %v, want errors for the slow and broken filters only", err)
+	}
+	if len(log) != 0 {
+		t.Errorf("Logger still has %d filters after CloseContext", len(log))
+	}
+}
+
+// stuckWriter blocks in LogWrite until released, signalling when it is stuck
+type stuckWriter struct {
+	stuck   chan struct{}
+	release chan struct{}
+}
+
+func newStuckWriter() *stuckWriter {
+	return &stuckWriter{stuck: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (w *stuckWriter) LogWrite(rec *LogRecord) {
+	select {
+	case w.stuck <- struct{}{}:
+	default:
+	}
+	<-w.release
+}
+
+func (w *stuckWriter) Close() {}
+
+func TestStuckWriterDoesNotBlockOtherLoggers(t *testing.T) {
+	w := newStuckWriter()
+	stuck := make(Logger).AddFilter("stuck", FINEST, w)
+	go stuck.Info("never done")
+	<-w.stuck
+	defer close(w.release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		other := make(Logger)
+		other.AddFilter("null", FINEST, NewNullLogWriter())
+		other.Info("written")
+		other.RemoveFilter("null")
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("A writer stuck in LogWrite held up changes to another logger's filters")
+	}
+}
+
+func TestCloseContextStuckWriter(t *testing.T) {
+	w := newStuckWriter()
+	defer close(w.release)
+	log := make(Logger).AddFilter("stuck", FINEST, w)
+	go log.Info("never done")
+	<-w.stuck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := log.CloseContext(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("CloseContext took %s with a writer stuck in LogWrite, expected it to give up at the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), `filter "stuck": close timed out`) {
+		t.Errorf("CloseContext = %v, want a timeout for the stuck filter", err)
+	}
+}
+
+func TestLevelFromString(t *testing.T) {
+	for lvl := FINEST; lvl <= OFF; lvl++ {
+		got, ok := LevelFromString(lvl.String())
+		if !ok || got != lvl {
+			t.Errorf("LevelFromString(%q) = %d, %v; want %d, true", lvl.String(), got, ok, lvl)
+		}
+	}
+
+	for _, s := range []string{"WARN", "warning", ""} {
+		if _, ok := LevelFromString(s); ok {
+			t.Errorf("LevelFromString(%q) should fail", s)
+		}
+	}
+	if s := Level(-1).String(); s != "UNKNOWN" {
+		t.Errorf("Level(-1).String() = %q, want %q", s, "UNKNOWN")
+	}
+	if s := (OFF + 1).String(); s != "UNKNOWN" {
+		t.Errorf("(OFF+1).String() = %q, want %q", s, "UNKNOWN")
+	}
+}
+
+func TestOffLevel(t *testing.T) {
+	const configfile = "_off.xml"
+
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>muted</tag>
+    <type>muted</type>
+    <level>OFF</level>
+  </filter>
+</logging>`
+
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	defer os.Remove(configfile)
+
+	muted := new(recordingWriter)
+	RegisterWriterType("muted", func(props map[string]string, enabled bool) (LogWriter, error) {
+		return muted, nil
+	})
+	defer func() {
+		writerTypesMu.Lock()
+		delete(writerTypes, "muted")
+		writerTypesMu.Unlock()
+	}()
+
+	l := make(Logger)
+	if err := l.LoadConfiguration(configfile); err != nil {
+		t.Fatalf("LoadConfiguration: %s", err)
+	}
+	defer l.Close()
+	if lvl, _ := l.GetLevel("muted"); lvl != OFF {
+		t.Fatalf("Expected muted to be set to level %s, found %s", OFF, lvl)
+	}
+
+	w := new(recordingWriter)
+	l.AddFilter("rec", FINEST, w)
+	if err := l.SetLevel("rec", OFF); err != nil {
+		t.Fatalf("SetLevel: %s", err)
+	}
+
+	l.Critical("suppressed")
+	l.Log(CRITICAL, "source", "suppressed")
+	if msgs := append(w.messages(), muted.messages()...); len(msgs) != 0 {
+		t.Errorf("Expected CRITICAL to be suppressed at OFF, got %v", msgs)
+	}
+
+	// OFF is not a level messages can be logged at
+	l.SetLevel("rec", FINEST)
+	l.Log(OFF, "source", "suppressed")
+	l.Critical("passed")
+	if msgs := w.messages(); len(msgs) != 1 || msgs[0] != "passed" {
+		t.Errorf("Expected only the CRITICAL message to pass, got %v", msgs)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limited, unlimited := new(recordingWriter), new(recordingWriter)
+	l := make(Logger)
+	l.AddFilter("limited", FINEST, limited)
+	l.AddFilter("unlimited", FINEST, unlimited)
+	l["limited"].SetRateLimit(10, 10).SetRateLimitExempt(CRITICAL)
+
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		l.Error("flood")
+	}
+	l.Critical("exempt")
+	elapsed := time.Since(start)
+	l.Close()
+
+	// The burst, plus whatever trickled in while the loop ran
+	msgs := limited.messages()
+	allowed := 10 + int(elapsed.Seconds()*10) + 1
+	if n := len(msgs) - 2; n < 10 || n > allowed {
+		t.Errorf("Expected about 10 records through the limit, found %d", n)
+	}
+	if msgs[len(msgs)-2] != "exempt" {
+		t.Errorf("Expected the CRITICAL record to bypass the limit, found %q", msgs[len(msgs)-2])
+	}
+	if want := fmt.Sprintf("dropped %d messages", 1000-(len(msgs)-2)); msgs[len(msgs)-1] != want {
+		t.Errorf("Expected summary %q, found %q", want, msgs[len(msgs)-1])
+	}
+
+	// Other tags are unaffected
+	if n := len(unlimited.messages()); n != 1001 {
+		t.Errorf("Expected 1001 records through the unlimited filter, found %d", n)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger).AddFilter("rec", FINEST, w)
+
+	std := l.StdLogger("thirdparty", WARNING)
+	std.Print("first line\nsecond line")
+	std.Printf("third %d", 3)
+
+	// Partial lines wait for their newline
+	lw := std.Writer()
+	lw.Write([]byte("fourth "))
+	if n := len(w.messages()); n != 3 {
+		t.Errorf("Expected the partial line to be held back, found %d records", n)
+	}
+	lw.Write([]byte("line\r\n"))
+
+	if got, want := fmt.Sprint(w.messages()), "[first line second line third 3 fourth line]"; got != want {
+		t.Errorf("Expected %s, found %s", want, got)
+	}
+	for _, rec := range w.records {
+		if rec.Level != WARNING || rec.Source != "thirdparty" {
+			t.Errorf("Expected a WARNING record from thirdparty, found %s from %q", rec.Level, rec.Source)
+		}
+	}
+}
+
+func TestLoggerWriter(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger).AddFilter("rec", FINEST, w)
+
+	input := "one\ntwo\n\nthree\nunterminated"
+	if _, err := io.Copy(l.Writer("exec", ERROR), strings.NewReader(input)); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+
+	if got, want := fmt.Sprint(w.messages()), "[one two  three]"; got != want {
+		t.Errorf("Expected %s, found %s", want, got)
+	}
+	for _, rec := range w.records {
+		if rec.Level != ERROR || rec.Source != "exec" {
+			t.Errorf("Expected an ERROR record from exec, found %s from %q", rec.Level, rec.Source)
+		}
+	}
+}
+
+type testContextKey string
+
+func TestLogCtx(t *testing.T) {
+	RegisterContextField(testContextKey("request"), "request_id")
+	RegisterContextField(testContextKey("user"), "user")
+	defer func() {
+		contextFieldsMu.Lock()
+		contextFields = nil
+		contextFieldsMu.Unlock()
+	}()
+
+	mw := NewMemoryLogWriter(10).SetFormat("%s %M")
+	l := make(Logger).AddFilter("memory", FINEST, mw)
+
+	ctx := context.WithValue(context.Background(), testContextKey("request"), "abc123")
+	l.LogCtx(ctx, INFO, "handled %s", "/index")
+	l.LogCtx(context.Background(), INFO, "no request")
+
+	lines := mw.Dump()
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, found %q", lines)
+	}
+	if want := "handled /index request_id=abc123"; !strings.HasSuffix(lines[0], want) {
+		t.Errorf("Expected %q to end with %q", lines[0], want)
+	}
+	if !strings.HasPrefix(lines[0], "log4go_test.go:") {
+		t.Errorf("Expected the caller as the source, found %q", lines[0])
+	}
+	if want := " no request"; !strings.HasSuffix(lines[1], want) {
+		t.Errorf("Expected %q to end with %q", lines[1], want)
+	}
+}
+
+func TestRegisterContextFunc(t *testing.T) {
+	RegisterContextFunc(func(ctx context.Context) map[string]interface{} {
+		if span, ok := ctx.Value(testContextKey("span")).(string); ok {
+			return map[string]interface{}{"trace_id": "t-" + span, "span_id": span}
+		}
+		return nil
+	})
+	defer func() {
+		contextFieldsMu.Lock()
+		contextFuncs = nil
+		contextFieldsMu.Unlock()
+	}()
+
+	mw := NewMemoryLogWriter(10).SetFormat("%M")
+	l := make(Logger).AddFilter("memory", FINEST, mw)
+
+	l.LogCtx(context.WithValue(context.Background(), testContextKey("span"), "42"), INFO, "traced")
+	l.LogCtx(context.Background(), INFO, "untraced")
+
+	lines := mw.Dump()
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, found %q", lines)
+	}
+	if want := "traced span_id=42 trace_id=t-42"; lines[0] != want {
+		t.Errorf("Expected %q, found %q", want, lines[0])
+	}
+	if want := "untraced"; lines[1] != want {
+		t.Errorf("Expected %q, found %q", want, lines[1])
+	}
+}
+
+func TestGoroutineIDFormat(t *testing.T) {
+	mw := NewMemoryLogWriter(10).SetFormat("%g %M")
+	l := make(Logger).AddFilter("memory", FINEST, mw)
+
+	l.Info("main")
+	done := make(chan bool)
+	go func() {
+		l.Info("other")
+		close(done)
+	}()
+	<-done
+
+	lines := mw.Dump()
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, found %q", lines)
+	}
+	var ids [2]int64
+	for i, line := range lines {
+		if _, err := fmt.Sscanf(line, "%d", &ids[i]); err != nil || ids[i] <= 0 {
+			t.Fatalf("Expected a goroutine ID in %q", line)
+		}
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("Expected different goroutine IDs, found %d twice", ids[0])
+	}
+	if ids[0] != goroutineID() {
+		t.Errorf("Expected the ID of the logging goroutine %d, found %d", goroutineID(), ids[0])
+	}
+}
+
+func logFromNamedFunction(l Logger) {
+	l.Info("named")
+}
+
+func TestSourceFunctionFormat(t *testing.T) {
+	w := new(recordingWriter)
+	l := make(Logger).AddFilter("rec", INFO, w)
+
+	logFromNamedFunction(l)
+	if len(w.records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(w.records))
+	}
+
+	got := FormatLogRecord("%F %s", w.records[0])
+	if !strings.HasPrefix(got, "log4go.logFromNamedFunction log4go_test.go:") {
+		t.Errorf("Unexpected caller formatting: %q", got)
+	}
+
+	// Records without a known file still format the function
+	rec := newLogRecord(INFO, "example.com/pkg.Func:12", "message")
+	if got, want := FormatLogRecord("%F|%s|%M", rec), "pkg.Func||message\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// logThroughHelper logs the way a wrapper library would
+func logThroughHelper(l Logger, msg string) {
+	l.Info(msg)
 }
 
-func TestLogOutput(t *testing.T) {
-	const (
-		expected = "fdf3e51e444da56b4cb400f30bc47424"
-	)
+func TestCallerSkip(t *testing.T) {
+	rw, skipping := &recordingWriter{}, &recordingWriter{}
+	l := make(Logger)
+	l.AddFilter("recorder", FINEST, rw)
+	l.AddFilter("skipping", FINEST, skipping)
+	defer l.Close()
 
-	// Unbuffered output
-	defer func(buflen int) {
-		LogBufferLength = buflen
-	}(LogBufferLength)
-	LogBufferLength = 0
+	source := func(w *recordingWriter) string {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.records[len(w.records)-1].Source
+	}
+
+	logThroughHelper(l, "default")
+	if src := source(rw); !strings.Contains(src, ".logThroughHelper:") {
+		t.Errorf("Source with the default skip = %q, want the helper", src)
+	}
+
+	l.SetCallerSkip(1)
+	logThroughHelper(l, "skipped")
+	if src := source(rw); !strings.Contains(src, ".TestCallerSkip:") {
+		t.Errorf("Source with skip 1 = %q, want the test", src)
+	}
+	if file := rw.records[len(rw.records)-1].file; !strings.Contains(file, "log4go_test.go:") {
+		t.Errorf("file with skip 1 = %q, want the test file", file)
+	}
+
+	// A filter can skip further than its logger
+	l.SetCallerSkip(0)
+	l["skipping"].SetCallerSkip(1)
+	logThroughHelper(l, "per filter")
+	if src := source(rw); !strings.Contains(src, ".logThroughHelper:") {
+		t.Errorf("Source for the filter without a skip = %q, want the helper", src)
+	}
+	if src := source(skipping); !strings.Contains(src, ".TestCallerSkip:") {
+		t.Errorf("Source for the filter with skip 1 = %q, want the test", src)
+	}
+}
 
+func TestSourceCapture(t *testing.T) {
+	rw := &recordingWriter{}
 	l := make(Logger)
+	l.AddFilter("recorder", FINEST, rw)
+	defer l.Close()
 
-	// Delete and open the output log without a timestamp (for a constant md5sum)
-	l.AddFilter("file", FINEST, NewFileLogWriter(testLogFile, false).SetFormat("[%L] %M"))
-	defer os.Remove(testLogFile)
+	l.SetSourceCapture(false)
+	l.Info("no source")
+	rec := rw.records[0]
+	if rec.Source != "" || rec.file != "" {
+		t.Errorf("Source = %q, file = %q, want both empty", rec.Source, rec.file)
+	}
+	if got, want := FormatLogRecord("(%S) %M", rec), "(?) no source\n"; got != want {
+		t.Errorf("FormatLogRecord = %q, want %q", got, want)
+	}
 
-	// Send some log messages
-	l.Log(CRITICAL, "testsrc1", fmt.Sprintf("This message is level %d", int(CRITICAL)))
-	l.Logf(ERROR, "This message is level %v", ERROR)
-	l.Logf(WARNING, "This message is level %s", WARNING)
-	l.Logc(INFO, func() string { return "This message is level INFO" })
-	l.Trace("This message is level %d", int(TRACE))
-	l.Debug("This message is level %s", DEBUG)
-	l.Fine(func() string { return fmt.Sprintf("This message is level %v", FINE) })
-	l.Finest("This message is level %v", FINEST)
-	l.Finest(FINEST, "is also this message's level")
+	l.SetSourceCapture(true)
+	l.Info("source")
+	if src := rw.records[1].Source; !strings.Contains(src, ".TestSourceCapture:") {
+		t.Errorf("Source = %q, want the test", src)
+	}
+}
+
+func TestRecordPool(t *testing.T) {
+	// A record given to a writer which may keep it is never reused
+	rec := newRecord(LogRecord{Message: "kept"})
+	rec.hold(NewNullLogWriter())
+	rec.hold(&FileLogWriter{})
+	rec.hold(&recordingWriter{})
+	rec.release()
+	rec.release()
+	if rec.Message != "kept" {
+		t.Fatalf("record kept by a writer was reset: %+v", rec)
+	}
 
+	// Records are not reused while queued for a writer, or kept by one
+	const N = 1000
+	logfile := filepath.Join(t.TempDir(), "pool.log")
+	w := NewFileLogWriter(logfile, false).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	rw := &recordingWriter{}
+	l := make(Logger)
+	l.AddFilter("file", FINEST, w)
+	l.AddFilter("null", FINEST, NewNullLogWriter())
+	l.AddFilter("recorder", FINEST, rw)
+	for i := 0; i < N; i++ {
+		l.Info("record %d", i)
+	}
 	l.Close()
 
-	contents, err := ioutil.ReadFile(testLogFile)
+	contents, err := ioutil.ReadFile(logfile)
 	if err != nil {
-		t.Fatalf("Could not read output log: %s", err)
+		t.Fatalf("read(%q): %s", logfile, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	msgs := rw.messages()
+	if len(lines) != N || len(msgs) != N {
+		t.Fatalf("got %d lines and %d recorded messages, want %d", len(lines), len(msgs), N)
+	}
+	for i := 0; i < N; i++ {
+		want := fmt.Sprintf("record %d", i)
+		if lines[i] != want || msgs[i] != want {
+			t.Fatalf("record %d: file has %q, recorder has %q", i, lines[i], msgs[i])
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	l := make(Logger)
+	l.AddFilter("info", INFO, NewNullLogWriter())
+	l.AddFilter("off", OFF, NewNullLogWriter())
+	defer l.Close()
+
+	for lvl, want := range map[Level]bool{DEBUG: false, INFO: true, CRITICAL: true, OFF: false} {
+		if got := l.Enabled(lvl); got != want {
+			t.Errorf("Enabled(%s) = %v, want %v", lvl, got, want)
+		}
 	}
 
-	sum := md5.New()
-	sum.Write(contents)
-	if sumstr := hex.EncodeToString(sum.Sum(nil)); sumstr != expected {
-		t.Errorf("--- Log Contents:\n%s---", string(contents))
-		t.Fatalf("Checksum does not match: %s (expecting %s)", sumstr, expected)
+	// Suppressed calls return before formatting anything
+	type costly struct{ int }
+	if allocs := testing.AllocsPerRun(100, func() {
+		l.Debug("suppressed %d %s", 42, "message")
+		l.Debug(costly{42}, "message")
+	}); allocs != 0 {
+		t.Errorf("suppressed Debug made %v allocations, want 0", allocs)
 	}
 }
 
@@ -383,7 +3582,7 @@ func TestXMLConfig(t *testing.T) {
 	}
 
 	// Make sure they're the right type
-	if _, ok := log["stdout"].LogWriter.(ConsoleLogWriter); !ok {
+	if _, ok := log["stdout"].LogWriter.(*ConsoleLogWriter); !ok {
 		t.Fatalf("XMLConfig: Expected stdout to be ConsoleLogWriter, found %T", log["stdout"].LogWriter)
 	}
 	if _, ok := log["file"].LogWriter.(*FileLogWriter); !ok {
@@ -418,6 +3617,669 @@ func TestXMLConfig(t *testing.T) {
 	os.Rename(configfile, "examples/"+configfile) // Keep this so that an example with the documentation is available
 }
 
+func TestJSONConfig(t *testing.T) {
+	const (
+		xmlfile  = "_jsonconfig.xml"
+		jsonfile = "_jsonconfig.json"
+	)
+
+	xmlconf := `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>DEBUG</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">_jsonconfig.log</property>
+    <property name="format">[%D %T] [%L] %M</property>
+    <property name="rotate">true</property>
+    <property name="maxsize">2M</property>
+    <property name="maxlines">3K</property>
+    <property name="daily">true</property>
+    <property name="keepnum">4</property>
+  </filter>
+  <filter enabled="true">
+    <tag>xmllog</tag>
+    <type>xml</type>
+    <level>TRACE</level>
+    <property name="filename">_jsonconfig.xmllog</property>
+    <property name="maxrecords">6K</property>
+  </filter>
+  <filter enabled="false">
+    <tag>donotopen</tag>
+    <type>socket</type>
+    <level>FINEST</level>
+    <property name="endpoint">192.168.1.255:12124</property>
+  </filter>
+</logging>`
+
+	jsonconf := `{
+  "filters": [
+    {"enabled": true, "tag": "stdout", "type": "console", "level": "DEBUG"},
+    {"enabled": true, "tag": "file", "type": "file", "level": "FINEST",
+     "properties": {"filename": "_jsonconfig.log", "format": "[%D %T] [%L] %M",
+                    "rotate": true, "maxsize": "2M", "maxlines": "3K",
+                    "daily": "true", "keepnum": 4}},
+    {"enabled": "true", "tag": "xmllog", "type": "xml", "level": "TRACE",
+     "properties": {"filename": "_jsonconfig.xmllog", "maxrecords": "6K"}},
+    {"enabled": false, "tag": "donotopen", "type": "socket", "level": "FINEST",
+     "properties": {"endpoint": "192.168.1.255:12124"}}
+  ]
+}`
+
+	if err := ioutil.WriteFile(xmlfile, []byte(xmlconf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", xmlfile, err)
+	}
+	defer os.Remove(xmlfile)
+	if err := ioutil.WriteFile(jsonfile, []byte(jsonconf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", jsonfile, err)
+	}
+	defer os.Remove(jsonfile)
+	defer os.Remove("_jsonconfig.log")
+	defer os.Remove("_jsonconfig.xmllog")
+
+	xlog := make(Logger)
+	if err := xlog.LoadConfiguration(xmlfile); err != nil {
+		t.Fatalf("JSONConfig: XML load failed: %s", err)
+	}
+	defer xlog.Close()
+
+	jlog := make(Logger)
+	if err := jlog.LoadConfigurationJSON(jsonfile); err != nil {
+		t.Fatalf("JSONConfig: JSON load failed: %s", err)
+	}
+	defer jlog.Close()
+
+	if len(jlog) != len(xlog) {
+		t.Fatalf("JSONConfig: Expected %d filters, found %d", len(xlog), len(jlog))
+	}
+	for tag, xfilt := range xlog {
+		jfilt, ok := jlog[tag]
+		if !ok {
+			t.Errorf("JSONConfig: Expected %s logger", tag)
+			continue
+		}
+		if jfilt.Level != xfilt.Level {
+			t.Errorf("JSONConfig: Expected %s to be set to level %d, found %d", tag, xfilt.Level, jfilt.Level)
+		}
+		if jt, xt := fmt.Sprintf("%T", jfilt.LogWriter), fmt.Sprintf("%T", xfilt.LogWriter); jt != xt {
+			t.Errorf("JSONConfig: Expected %s to be %s, found %s", tag, xt, jt)
+		}
+	}
+
+	for _, tag := range []string{"file", "xmllog"} {
+		got := jlog[tag].LogWriter.(*FileLogWriter)
+		want := xlog[tag].LogWriter.(*FileLogWriter)
+		if got.filename != want.filename || got.format != want.format ||
+			got.header != want.header || got.trailer != want.trailer ||
+			got.maxlines != want.maxlines || got.maxsize != want.maxsize ||
+			got.daily != want.daily || got.rotate != want.rotate ||
+			got.keepNum != want.keepNum {
+			t.Errorf("JSONConfig: %s writer differs from XML configuration", tag)
+		}
+	}
+}
+
+func TestConfigEnvExpansion(t *testing.T) {
+	const (
+		configfile = "_envconfig.xml"
+		logfile    = "_envconfig_expanded.log"
+	)
+
+	defer os.Unsetenv("L4G_TEST_SUFFIX")
+	os.Setenv("L4G_TEST_SUFFIX", "expanded")
+	os.Unsetenv("L4G_TEST_UNSET")
+
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">_envconfig_${L4G_TEST_SUFFIX}.log</property>
+    <property name="format">[%L]$L4G_TEST_UNSET %M</property>
+  </filter>
+  <filter enabled="true">
+    <tag>socket</tag>
+    <type>socket</type>
+    <level>FINEST</level>
+    <property name="endpoint">127.0.0.1:12124</property>
+    <property name="protocol">udp</property>
+    <property name="format">${L4G_TEST_SUFFIX} %M</property>
+  </filter>
+</logging>`
+
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	defer os.Remove(configfile)
+	defer os.Remove(logfile)
+
+	log := make(Logger)
+	if err := log.LoadConfiguration(configfile); err != nil {
+		t.Fatalf("EnvExpansion: load failed: %s", err)
+	}
+	defer log.Close()
+
+	flw := log["file"].LogWriter.(*FileLogWriter)
+	if flw.filename != logfile {
+		t.Errorf("EnvExpansion: Expected filename %q, found %q", logfile, flw.filename)
+	}
+	if fname := flw.file.Name(); fname != logfile {
+		t.Errorf("EnvExpansion: Expected file to have opened %s, found %s", logfile, fname)
+	}
+	if want := "[%L] %M"; flw.format != want {
+		t.Errorf("EnvExpansion: Expected format %q, found %q", want, flw.format)
+	}
+	slw := log["socket"].LogWriter.(*SocketLogWriter)
+	if want := "expanded %M"; slw.format != want {
+		t.Errorf("EnvExpansion: Expected socket format %q, found %q", want, slw.format)
+	}
+}
+
+func TestConfigDefaultFormat(t *testing.T) {
+	dir := t.TempDir()
+	conf := `<logging>
+  <format>[%L] %M</format>
+  <filter enabled="true">
+    <tag>default</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">` + filepath.Join(dir, "default.log") + `</property>
+  </filter>
+  <filter enabled="true">
+    <tag>override</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">` + filepath.Join(dir, "override.log") + `</property>
+    <property name="format">%M</property>
+  </filter>
+</logging>`
+
+	log := make(Logger)
+	if err := log.LoadConfigurationFromReader(strings.NewReader(conf), "default.xml"); err != nil {
+		t.Fatalf("DefaultFormat: load failed: %s", err)
+	}
+	log.Info("hello")
+	log.Close()
+
+	for name, want := range map[string]string{"default.log": "[INFO] hello\n", "override.log": "hello\n"} {
+		if contents, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(contents) != want {
+			t.Errorf("DefaultFormat: Expected %s to contain %q, found %q", name, want, contents)
+		}
+	}
+
+	// The format may also be given as a property, and applies to consoles
+	conf = `<logging>
+  <property name="format">%L %M</property>
+  <filter enabled="true">
+    <tag>console</tag>
+    <type>console</type>
+    <level>OFF</level>
+  </filter>
+</logging>`
+	if err := log.LoadConfigurationFromReader(strings.NewReader(conf), "property.xml"); err != nil {
+		t.Fatalf("DefaultFormat: load failed: %s", err)
+	}
+	if got := log["console"].LogWriter.(*ConsoleLogWriter).format; got != "%L %M" {
+		t.Errorf("DefaultFormat: Expected console format %q, found %q", "%L %M", got)
+	}
+	log.Close()
+}
+
+func TestConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	base := `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>INFO</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>WARNING</level>
+    <property name="filename">` + filepath.Join(dir, "base.log") + `</property>
+  </filter>
+</logging>`
+	service := `<logging>
+  <include file="base.xml"/>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>DEBUG</level>
+    <property name="filename">` + filepath.Join(dir, "service.log") + `</property>
+  </filter>
+</logging>`
+	for name, conf := range map[string]string{"base.xml": base, "service.xml": service} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(conf), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err)
+		}
+	}
+
+	log := make(Logger)
+	if err := log.LoadConfiguration(filepath.Join(dir, "service.xml")); err != nil {
+		t.Fatalf("Include: load failed: %s", err)
+	}
+	got := log.Filters()
+	log.Close()
+	want := []FilterInfo{
+		{Tag: "file", Level: DEBUG, Writer: "file"},
+		{Tag: "stdout", Level: INFO, Writer: "console"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Include: Expected filters %v, found %v", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "base.log")); !os.IsNotExist(err) {
+		t.Errorf("Include: Expected the overridden file filter not to be opened")
+	}
+
+	// Files which include each other are rejected
+	loop := `<logging><include file="service.xml"/></logging>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.xml"), []byte(loop), 0644); err != nil {
+		t.Fatalf("Could not write base.xml: %s", err)
+	}
+	err := make(Logger).LoadConfiguration(filepath.Join(dir, "service.xml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Include: Expected an include cycle error, found %v", err)
+	}
+}
+
+func TestLoadConfigurationFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/base.xml": {Data: []byte(`<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>INFO</level>
+  </filter>
+</logging>`)},
+		"conf/service.xml": {Data: []byte(`<logging>
+  <include file="base.xml"/>
+  <filter enabled="true">
+    <tag>null</tag>
+    <type>null</type>
+    <level>ERROR</level>
+  </filter>
+</logging>`)},
+		"conf/service.json": {Data: []byte(`{"filters": [{"enabled": true, "tag": "null", "type": "null", "level": "WARNING"}]}`)},
+	}
+
+	log := make(Logger)
+	defer log.Close()
+	if err := log.LoadConfigurationFS(fsys, "conf/service.xml"); err != nil {
+		t.Fatalf("LoadConfigurationFS: load failed: %s", err)
+	}
+	want := []FilterInfo{
+		{Tag: "null", Level: ERROR, Writer: "null"},
+		{Tag: "stdout", Level: INFO, Writer: "console"},
+	}
+	if got := log.Filters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadConfigurationFS: Expected filters %v, found %v", want, got)
+	}
+
+	if err := log.LoadConfigurationFS(fsys, "conf/service.json"); err != nil {
+		t.Fatalf("LoadConfigurationFS: JSON load failed: %s", err)
+	}
+	want = []FilterInfo{{Tag: "null", Level: WARNING, Writer: "null"}}
+	if got := log.Filters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadConfigurationFS: Expected filters %v, found %v", want, got)
+	}
+
+	if err := log.LoadConfigurationFS(fsys, "conf/missing.xml"); err == nil {
+		t.Errorf("LoadConfigurationFS: Expected an error for a missing file")
+	}
+}
+
+func TestLoadConfigurationWarnings(t *testing.T) {
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>null</tag>
+    <type>null</type>
+    <level>INFO</level>
+    <property name="colour">blue</property>
+  </filter>
+</logging>`
+
+	log := make(Logger)
+	defer log.Close()
+	warnings, err := log.LoadConfigurationFromReaderWarnings(strings.NewReader(conf), "warnings.xml")
+	if err != nil {
+		t.Fatalf("Warnings: load failed: %s", err)
+	}
+	want := []string{`LoadConfiguration: Warning: Unknown property "colour" for null filter in warnings.xml`}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("Warnings: Expected %q, found %q", want, warnings)
+	}
+	if _, ok := log["null"]; !ok {
+		t.Errorf("Warnings: Expected the filter to be loaded despite the warning")
+	}
+
+	// A clean configuration has no warnings
+	conf = strings.Replace(conf, `<property name="colour">blue</property>`, "", 1)
+	if warnings, err := log.LoadConfigurationFromReaderWarnings(strings.NewReader(conf), "clean.xml"); err != nil || len(warnings) != 0 {
+		t.Errorf("Warnings: Expected no warnings or error, found %q, %v", warnings, err)
+	}
+}
+
+func TestStrictConfig(t *testing.T) {
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>null</tag>
+    <type>null</type>
+    <level>INFO</level>
+    <property name="colour">blue</property>
+  </filter>
+</logging>`
+
+	log := make(Logger)
+	defer log.Close()
+	if _, err := log.LoadConfigurationFromReaderWarnings(strings.NewReader(conf), "strict.xml"); err != nil {
+		t.Errorf("StrictConfig: Expected normal mode to only warn, found %v", err)
+	}
+
+	SetStrictConfig(true)
+	defer SetStrictConfig(false)
+	err := log.LoadConfigurationFromReader(strings.NewReader(conf), "strict.xml")
+	var errs ConfigErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("StrictConfig: Expected one ConfigErrors error, found %v", err)
+	}
+	if want := "LoadConfiguration: Error: Unknown property \"colour\" for null filter in strict.xml\n"; errs[0].Error() != want {
+		t.Errorf("StrictConfig: Expected %q, found %q", want, errs[0].Error())
+	}
+	if len(log) != 0 {
+		t.Errorf("StrictConfig: Expected no filters to be loaded, found %d", len(log))
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for _, value := range []string{"true", "TRUE", "True", "1", "yes", "Yes", "on", "ON"} {
+		if got := parseBool("bool.xml", "file", "daily", value); !got {
+			t.Errorf("parseBool(%q) = false, want true", value)
+		}
+	}
+	for _, value := range []string{"false", "FALSE", "False", "0", "no", "NO", "off", "Off"} {
+		if got := parseBool("bool.xml", "file", "daily", value); got {
+			t.Errorf("parseBool(%q) = true, want false", value)
+		}
+	}
+
+	// Anything else is false, with a warning
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>socket</tag>
+    <type>socket</type>
+    <level>INFO</level>
+    <property name="endpoint">127.0.0.1:1</property>
+    <property name="protocol">udp</property>
+    <property name="reconnect">maybe</property>
+  </filter>
+  <filter enabled="off">
+    <tag>disabled</tag>
+    <type>null</type>
+    <level>INFO</level>
+  </filter>
+</logging>`
+	log := make(Logger)
+	defer log.Close()
+	warnings, err := log.LoadConfigurationFromReaderWarnings(strings.NewReader(conf), "bool.xml")
+	if err != nil {
+		t.Fatalf("ParseBool: load failed: %s", err)
+	}
+	want := []string{`LoadConfiguration: Warning: Property "reconnect" for socket filter in bool.xml is not a boolean: maybe`}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("ParseBool: Expected warnings %q, found %q", want, warnings)
+	}
+	if _, ok := log["disabled"]; ok {
+		t.Errorf("ParseBool: Expected the filter with enabled=\"off\" not to be loaded")
+	}
+}
+
+func TestStrToNumSuffix(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		mult int
+		want int
+	}{
+		{"0", 1024, 0},
+		{"10", 1024, 10},
+		{"10k", 1024, 10 << 10},
+		{"10M", 1024, 10 << 20},
+		{"1G", 1024, 1 << 30},
+		{"6K", 1000, 6000},
+		{"1KiB", 1000, 1024},
+		{"1KB", 1024, 1000},
+		{"2mib", 1000, 2 << 20},
+		{"2MB", 1024, 2000000},
+		{"1GiB", 1000, 1 << 30},
+		{"1GB", 1024, 1000000000},
+	} {
+		if got, err := strToNumSuffix(tc.str, tc.mult); err != nil || got != tc.want {
+			t.Errorf("strToNumSuffix(%q, %d) = %d, %v; want %d", tc.str, tc.mult, got, err, tc.want)
+		}
+	}
+	for _, str := range []string{"", "M", "10MBB", "ten", "1.5M", "1iB", "10B", "KB", "1XB"} {
+		if _, err := strToNumSuffix(str, 1024); err == nil {
+			t.Errorf("strToNumSuffix(%q) succeeded, want an error", str)
+		}
+	}
+
+	// Malformed values are configuration errors
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>INFO</level>
+    <property name="filename">` + filepath.Join(t.TempDir(), "size.log") + `</property>
+    <property name="maxsize">10MBB</property>
+  </filter>
+</logging>`
+	err := make(Logger).LoadConfigurationFromReader(strings.NewReader(conf), "size.xml")
+	want := "LoadConfiguration: Error: Could not parse property \"maxsize\" for file filter in size.xml: invalid number \"10MBB\"\n"
+	if err == nil || err.Error() != want {
+		t.Errorf("StrToNumSuffix: Expected error %q, found %v", want, err)
+	}
+}
+
+func TestRegisterWriterType(t *testing.T) {
+	const configfile = "_custom.xml"
+
+	var gotProps map[string]string
+	var gotEnabled bool
+	w := &recordingWriter{}
+	RegisterWriterType("custom", func(props map[string]string, enabled bool) (LogWriter, error) {
+		gotProps, gotEnabled = props, enabled
+		if len(props["queue"]) == 0 {
+			return nil, fmt.Errorf("custom: queue missing")
+		}
+		return w, nil
+	})
+	defer func() {
+		writerTypesMu.Lock()
+		delete(writerTypes, "custom")
+		writerTypesMu.Unlock()
+	}()
+
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>custom</tag>
+    <type>custom</type>
+    <level>INFO</level>
+    <property name="queue">events</property>
+    <property name="batch">10</property>
+  </filter>
+</logging>`
+
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	defer os.Remove(configfile)
+
+	log := make(Logger)
+	if err := log.LoadConfiguration(configfile); err != nil {
+		t.Fatalf("LoadConfiguration: %s", err)
+	}
+	defer log.Close()
+
+	if !gotEnabled {
+		t.Errorf("Expected the factory to be called with enabled=true")
+	}
+	if want := map[string]string{"queue": "events", "batch": "10"}; fmt.Sprint(gotProps) != fmt.Sprint(want) {
+		t.Errorf("Expected properties %v, found %v", want, gotProps)
+	}
+	if filt, ok := log["custom"]; !ok || filt.LogWriter != w || filt.Level != INFO {
+		t.Fatalf("Expected the custom writer to be installed at INFO, found %v", log["custom"])
+	}
+
+	log.Info("to the queue")
+	if msgs := w.messages(); len(msgs) != 1 || msgs[0] != "to the queue" {
+		t.Errorf("Expected the custom writer to receive the message, found %q", msgs)
+	}
+}
+
+func TestValidateConfiguration(t *testing.T) {
+	const configfile = "_validate.xml"
+
+	conf := `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>LOUD</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>INFO</level>
+    <property name="filename">_validate.log</property>
+  </filter>
+  <filter enabled="true">
+    <tag>socket</tag>
+    <type>socket</type>
+    <level>INFO</level>
+  </filter>
+</logging>`
+
+	if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", configfile, err)
+	}
+	defer os.Remove(configfile)
+
+	err := ValidateConfiguration(configfile)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigErrors, found %#v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, found %d: %s", len(errs), err)
+	}
+	if !strings.Contains(errs[0].Error(), "LOUD") {
+		t.Errorf("Expected the unknown level to be reported, found %q", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), `"endpoint"`) {
+		t.Errorf("Expected the missing endpoint to be reported, found %q", errs[1])
+	}
+
+	// Nothing is opened while validating
+	if _, err := os.Stat("_validate.log"); !os.IsNotExist(err) {
+		os.Remove("_validate.log")
+		t.Errorf("Expected _validate.log not to be created")
+	}
+}
+
+func TestWatchConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(d time.Duration) { ConfigWatchInterval = d }(ConfigWatchInterval)
+	ConfigWatchInterval = 10 * time.Millisecond
+
+	reloadErrs := make(chan error, 10)
+	defer func() { ConfigReloadError = nil }()
+	ConfigReloadError = func(err error) { reloadErrs <- err }
+
+	log := make(Logger)
+	configfile := filepath.Join(dir, "watch.xml")
+	modified := time.Now().Add(-time.Hour)
+	writeConfig := func(level string) {
+		conf := `<logging>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>` + level + `</level>
+    <property name="filename">` + filepath.Join(dir, "watch.log") + `</property>
+  </filter>
+</logging>`
+		if err := ioutil.WriteFile(configfile, []byte(conf), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", configfile, err)
+		}
+		// Make sure the modification time moves on coarse filesystems
+		modified = modified.Add(time.Second)
+		os.Chtimes(configfile, modified, modified)
+	}
+	waitLevel := func(want Level) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if lvl, ok := log.GetLevel("file"); ok && lvl == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for level %s", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	writeConfig("WARNING")
+	stop, err := log.WatchConfiguration(configfile)
+	if err != nil {
+		t.Fatalf("WatchConfiguration: %s", err)
+	}
+	defer log.Close()
+	defer stop()
+	waitLevel(WARNING)
+
+	// Keep logging while the configuration is swapped out
+	quit := make(chan bool)
+	logging := make(chan bool)
+	go func() {
+		defer close(logging)
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+				log.Info("watching")
+			}
+		}
+	}()
+
+	writeConfig("DEBUG")
+	waitLevel(DEBUG)
+
+	// A broken configuration is reported and leaves the previous one active
+	writeConfig("LOUD")
+	select {
+	case err := <-reloadErrs:
+		if !strings.Contains(err.Error(), "LOUD") {
+			t.Errorf("Unexpected reload error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for reload error")
+	}
+	if lvl, _ := log.GetLevel("file"); lvl != DEBUG {
+		t.Errorf("Expected level %s to survive a failed reload, found %s", DEBUG, lvl)
+	}
+
+	close(quit)
+	<-logging
+}
+
 func BenchmarkFormatLogRecord(b *testing.B) {
 	const updateEvery = 1
 	rec := &LogRecord{
@@ -436,6 +4298,25 @@ func BenchmarkFormatLogRecord(b *testing.B) {
 	}
 }
 
+func BenchmarkFormatLogRecordCompiled(b *testing.B) {
+	rec := newLogRecord(CRITICAL, "source", "message")
+	const format = "[%D %T] [%L] (%S) %M | %s %F"
+
+	b.Run("compiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			FormatLogRecord(format, rec)
+		}
+	})
+	// Parsing the format for every record, as was once done
+	b.Run("uncompiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			compileFormat(format).render(rec, "")
+		}
+	})
+}
+
 func BenchmarkConsoleLog(b *testing.B) {
 	/* This doesn't seem to work on OS X
 	sink, err := os.Open(os.DevNull)
@@ -468,6 +4349,17 @@ func BenchmarkConsoleUtilLog(b *testing.B) {
 	}
 }
 
+func BenchmarkSuppressedDebug(b *testing.B) {
+	sl := NewDefaultLogger(INFO)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sl.Debug("%s is a log message with level %d", "This", DEBUG)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { sl.Debug("suppressed %d", 42) }); allocs != 0 {
+		b.Errorf("suppressed Debug made %v allocations, want 0", allocs)
+	}
+}
+
 func BenchmarkConsoleUtilNotLog(b *testing.B) {
 	sl := NewDefaultLogger(INFO)
 	for i := 0; i < b.N; i++ {
@@ -475,6 +4367,75 @@ func BenchmarkConsoleUtilNotLog(b *testing.B) {
 	}
 }
 
+func BenchmarkSourceCapture(b *testing.B) {
+	for _, capture := range []bool{true, false} {
+		b.Run(fmt.Sprintf("capture=%v", capture), func(b *testing.B) {
+			sl := make(Logger)
+			sl.AddFilter("null", INFO, NewNullLogWriter())
+			sl.SetSourceCapture(capture)
+			defer sl.Close()
+			for i := 0; i < b.N; i++ {
+				sl.Info("This is a log message")
+			}
+		})
+	}
+}
+
+// discardWriter discards records like NullLogWriter, but as far as the
+// logger knows, it may keep them
+type discardWriter struct{}
+
+func (discardWriter) LogWrite(rec *LogRecord) {}
+func (discardWriter) Close()                  {}
+
+func BenchmarkRecordPool(b *testing.B) {
+	for _, tc := range []struct {
+		name   string
+		writer LogWriter
+	}{
+		{"reused", NewNullLogWriter()},
+		{"kept", discardWriter{}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			sl := make(Logger)
+			sl.AddFilter("bench", INFO, tc.writer)
+			sl.SetSourceCapture(false)
+			defer sl.Close()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sl.Info("This is a log message")
+			}
+		})
+	}
+}
+
+func BenchmarkSocketLog(b *testing.B) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, batch := range []int{0, maxUDPBatch} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			sl := make(Logger)
+			sl.AddFilter("socket", INFO, NewSocketLogWriter("udp", conn.LocalAddr().String()).SetBatch(batch, 100*time.Millisecond))
+			defer sl.Close()
+			for i := 0; i < b.N; i++ {
+				sl.Log(WARNING, "here", "This is a log message")
+			}
+		})
+	}
+}
+
 func BenchmarkFileLog(b *testing.B) {
 	sl := make(Logger)
 	b.StopTimer()
@@ -487,6 +4448,19 @@ func BenchmarkFileLog(b *testing.B) {
 	os.Remove("benchlog.log")
 }
 
+func BenchmarkBufferedFileLog(b *testing.B) {
+	sl := make(Logger)
+	b.StopTimer()
+	sl.AddFilter("file", INFO, NewBufferedFileLogWriter("benchlog.log", false, 4096))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		sl.Log(WARNING, "here", "This is a log message")
+	}
+	b.StopTimer()
+	sl.Close()
+	os.Remove("benchlog.log")
+}
+
 func BenchmarkFileNotLogged(b *testing.B) {
 	sl := make(Logger)
 	b.StopTimer()
@@ -0,0 +1,65 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+
+package log4go
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCloseOnSignal(t *testing.T) {
+	// The child logs buffered records, then is killed by SIGTERM
+	if logfile := os.Getenv("LOG4GO_CLOSE_ON_SIGNAL"); logfile != "" {
+		log := make(Logger)
+		log.AddFilter("file", FINEST, NewFileLogWriter(logfile, false).SetFormat("%M").SetBufferSize(1<<16))
+		log.CloseOnSignal()
+		log.Info("buffered")
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(10 * time.Second)
+		os.Exit(0)
+	}
+
+	logfile := filepath.Join(t.TempDir(), "signal.log")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCloseOnSignal$")
+	cmd.Env = append(os.Environ(), "LOG4GO_CLOSE_ON_SIGNAL="+logfile)
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Expected the child to be killed by SIGTERM, got %v", err)
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+		t.Errorf("Expected the child to be killed by SIGTERM, got %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(logfile); string(contents) != "buffered\n" {
+		t.Errorf("Unexpected log contents: %q", contents)
+	}
+}
+
+func TestCloseOnSignalStop(t *testing.T) {
+	log := make(Logger)
+	log.AddFilter("memory", FINEST, NewMemoryLogWriter(10))
+	stop := log.CloseOnSignal(syscall.SIGUSR1)
+	stop()
+	stop()
+
+	// With the handler stopped, the signal is ignored rather than closing the
+	// logger
+	sigs := make(chan os.Signal, 1)
+	defer signal.Stop(sigs)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	<-sigs
+	if len(log) != 1 {
+		t.Errorf("Expected the logger to stay open, found %d filters", len(log))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"strings"
+	"sync"
+)
+
+// This log writer keeps the most recent formatted records in memory, e.g. for
+// a debug endpoint or for checking what was logged in tests.
+type MemoryLogWriter struct {
+	mu sync.Mutex
+
+	format string
+
+	// A ring of formatted records; next is where the following one goes
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewMemoryLogWriter creates a new LogWriter which keeps the last capacity
+// records, formatted with FORMAT_DEFAULT unless SetFormat is used.  Records are
+// formatted as they are written, so LogWrite never blocks on other writers.
+func NewMemoryLogWriter(capacity int) *MemoryLogWriter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryLogWriter{
+		format: FORMAT_DEFAULT,
+		lines:  make([]string, capacity),
+	}
+}
+
+// This is the MemoryLogWriter's output method
+func (w *MemoryLogWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lines[w.next] = strings.TrimSuffix(FormatLogRecord(w.format, rec), "\n")
+	if w.next++; w.next == len(w.lines) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+// Close does nothing; the records stay available to Dump.
+func (w *MemoryLogWriter) Close() {
+}
+
+// Dump returns the retained records, oldest first, without trailing newlines.
+func (w *MemoryLogWriter) Dump() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.full {
+		return append([]string(nil), w.lines[:w.next]...)
+	}
+	lines := make([]string, 0, len(w.lines))
+	lines = append(lines, w.lines[w.next:]...)
+	return append(lines, w.lines[:w.next]...)
+}
+
+// Reset discards the retained records.
+func (w *MemoryLogWriter) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range w.lines {
+		w.lines[i] = ""
+	}
+	w.next, w.full = 0, false
+}
+
+// Set the logging format (chainable).  Must be called before the first log
+// message is written.
+func (w *MemoryLogWriter) SetFormat(format string) *MemoryLogWriter {
+	w.format = format
+	noteFormat(format)
+	return w
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// This log writer passes every record on to several other writers
+type MultiLogWriter struct {
+	writers []LogWriter
+
+	closeOnce sync.Once
+}
+
+// NewMultiLogWriter creates a new LogWriter which forwards each record to all
+// of the given writers, so that a single filter can write to several
+// destinations.  A writer which panics is reported on standard error and does
+// not stop the others from receiving the record.
+func NewMultiLogWriter(writers ...LogWriter) LogWriter {
+	return &MultiLogWriter{
+		writers: append([]LogWriter(nil), writers...),
+	}
+}
+
+// This is the MultiLogWriter's output method
+func (w *MultiLogWriter) LogWrite(rec *LogRecord) {
+	for i, child := range w.writers {
+		if err := safely(func() { child.LogWrite(rec) }); err != nil {
+			fmt.Fprintf(os.Stderr, "MultiLogWriter: writer %d: %s\n", i, err)
+		}
+	}
+}
+
+// Flush flushes each of the writers which is a Flusher, returning the first
+// error encountered.
+func (w *MultiLogWriter) Flush() error {
+	var first error
+	for _, child := range w.writers {
+		if f, ok := child.(Flusher); ok {
+			if err := f.Flush(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Close closes all of the writers, even if some of them panic.  Any panics are
+// reported together on standard error.  Calling it again does nothing.
+func (w *MultiLogWriter) Close() {
+	w.closeOnce.Do(w.close)
+}
+
+func (w *MultiLogWriter) close() {
+	var errs []string
+	for i, child := range w.writers {
+		if err := safely(child.Close); err != nil {
+			errs = append(errs, fmt.Sprintf("writer %d: %s", i, err))
+		}
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "MultiLogWriter: Close: %s\n", strings.Join(errs, "; "))
+	}
+}
+
+// safely calls f, returning any panic as an error
+func safely(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
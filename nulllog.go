@@ -0,0 +1,39 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+// This log writer discards everything written to it
+type NullLogWriter struct{}
+
+// NewNullLogWriter creates a new LogWriter which discards every record.  It
+// keeps a filter in place, with the dispatch path intact, while writing
+// nothing; useful for benchmarks and for muting a tag that may be
+// reconfigured later.
+func NewNullLogWriter() LogWriter {
+	return NullLogWriter{}
+}
+
+// This is the NullLogWriter's output method, which does nothing
+func (w NullLogWriter) LogWrite(rec *LogRecord) {
+}
+
+// Close does nothing
+func (w NullLogWriter) Close() {
+}
+
+func propsToNullLogWriter(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+	// Parse properties
+	for _, name := range sortedPropNames(props) {
+		switch name {
+		default:
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for null filter in %s\n", name, filename)
+		}
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !enabled {
+		return nil, nil
+	}
+
+	return NewNullLogWriter(), nil
+}
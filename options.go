@@ -0,0 +1,241 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// The settings of a Logger beyond its filters.  Logger is a map, so they are
+// kept here keyed by the identity of the map; the Logger is held too so the
+// key is not reused.
+type loggerOptions struct {
+	log Logger
+
+	// Called with each record dispatched, see SetHook
+	hooks []func(*LogRecord)
+
+	// Applied in order to the message of each record, see AddRedactor
+	redactors []redactor
+
+	// Frames to skip above the caller when finding the source
+	callerSkip int
+
+	// Leave the source of records empty rather than look it up
+	noSource bool
+
+	// Truncate messages longer than this many bytes, if it is positive
+	maxMessageLen int
+
+	// Give records their time in UTC rather than local time
+	utc bool
+
+	// Write records at or above floor to every filter, if floorSet
+	floor    Level
+	floorSet bool
+}
+
+var (
+	optionsMu  sync.RWMutex
+	options    = make(map[uintptr]*loggerOptions)
+	optionsSet int32 // non-zero if any Logger has options
+)
+
+// options returns the logger's settings, or nil if it has the defaults.  The
+// result must not be modified.
+func (log Logger) options() *loggerOptions {
+	if atomic.LoadInt32(&optionsSet) == 0 {
+		return nil
+	}
+
+	optionsMu.RLock()
+	opts := options[reflect.ValueOf(log).Pointer()]
+	optionsMu.RUnlock()
+	return opts
+}
+
+// setOptions changes the logger's settings by applying set to a copy of them
+func (log Logger) setOptions(set func(opts *loggerOptions)) {
+	key := reflect.ValueOf(log).Pointer()
+
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+
+	opts := loggerOptions{log: log}
+	if old := options[key]; old != nil {
+		opts = *old
+	}
+	set(&opts)
+	if len(opts.hooks) == 0 && len(opts.redactors) == 0 && opts.callerSkip == 0 && !opts.noSource && opts.maxMessageLen <= 0 && !opts.utc && !opts.floorSet {
+		delete(options, key)
+	} else {
+		options[key] = &opts
+	}
+	atomic.StoreInt32(&optionsSet, int32(len(options)))
+}
+
+// resetOptions restores the logger's default settings
+func (log Logger) resetOptions() {
+	optionsMu.Lock()
+	delete(options, reflect.ValueOf(log).Pointer())
+	atomic.StoreInt32(&optionsSet, int32(len(options)))
+	optionsMu.Unlock()
+}
+
+// SetCallerSkip makes the logger skip n more stack frames when finding the
+// source of a record, for when it is called through a helper of your own.  By
+// default (n is 0) the source is the function which called the logging method,
+// such as Info or Logf; with n of 1 it is the caller of that function, and so
+// on.  Records logged with Log, which are given their source, are unaffected.
+// Close restores the default.
+func (log Logger) SetCallerSkip(n int) {
+	if n < 0 {
+		n = 0
+	}
+	log.setOptions(func(opts *loggerOptions) {
+		opts.callerSkip = n
+	})
+}
+
+// SetSourceCapture changes whether the logger looks up the function, file and
+// line which logged each record (on by default).  Looking it up is one of the
+// main costs of logging a record, so turning it off helps in hot paths which do
+// not need it; the records' Source is then left empty and %S renders as "?".
+// Records logged with Log are given their source and are unaffected.  Close
+// restores the default.
+func (log Logger) SetSourceCapture(capture bool) {
+	log.setOptions(func(opts *loggerOptions) {
+		opts.noSource = !capture
+	})
+}
+
+// caller finds the source of a record for the intLog functions, which must
+// call it directly.  The depth is the frames from dispatch to the source.
+func (log Logger) caller() (src, file string, depth int) {
+	skip := 0
+	if opts := log.options(); opts != nil {
+		if opts.noSource {
+			return "", "", 0
+		}
+		skip = opts.callerSkip
+	}
+	src, file = callerSource(3 + skip)
+	return src, file, 3 + skip
+}
+
+// SetCallerSkip makes the filter skip n more stack frames than its logger
+// when finding the source of the records it writes (chainable), for a writer
+// which is only given records logged through a helper.  See
+// Logger.SetCallerSkip.
+func (filt *Filter) SetCallerSkip(n int) *Filter {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&filt.state().skip, int32(n))
+	return filt
+}
+
+// SetGlobalMinLevel lowers the level of every filter of the logger to at most
+// lvl while it is set, e.g. to force DEBUG records to be written during an
+// incident without editing the configuration: a filter writes a record if its
+// level is at least the lower of the filter's level and lvl.  Filters with a
+// lower level are unaffected, and the filters' own levels are left as they
+// are.  Use ClearGlobalMinLevel to remove it; Close removes it too.
+func (log Logger) SetGlobalMinLevel(lvl Level) {
+	log.setOptions(func(opts *loggerOptions) {
+		opts.floor, opts.floorSet = lvl, true
+	})
+}
+
+// ClearGlobalMinLevel removes the level set by SetGlobalMinLevel, so that each
+// filter writes the records at or above its own level again.
+func (log Logger) ClearGlobalMinLevel() {
+	log.setOptions(func(opts *loggerOptions) {
+		opts.floor, opts.floorSet = 0, false
+	})
+}
+
+// filterLevel returns the level from which a filter at lvl writes records,
+// lowered to the floor set by SetGlobalMinLevel.  opts may be nil.
+func (opts *loggerOptions) filterLevel(lvl Level) Level {
+	if opts != nil && opts.floorSet && opts.floor < lvl {
+		return opts.floor
+	}
+	return lvl
+}
+
+// SetUTC changes whether the records the logger dispatches have their time in
+// UTC rather than local time, the default, so that %D, %T, %z and the other
+// time directives, and the JSON and XML times, are rendered in UTC.  Hooks see
+// the converted time.  A FileLogWriter or ConsoleLogWriter may override this
+// with its own SetUTC.  Close restores the default.
+func (log Logger) SetUTC(utc bool) {
+	log.setOptions(func(opts *loggerOptions) {
+		opts.utc = utc
+	})
+}
+
+// recordIn returns rec with its time in loc, copying it, unless loc is nil
+func recordIn(rec *LogRecord, loc *time.Location) *LogRecord {
+	if loc == nil {
+		return rec
+	}
+	cp := *rec
+	cp.pooled = false
+	cp.Created = rec.Created.In(loc)
+	return &cp
+}
+
+// utcLocation returns the location which SetUTC on a writer selects
+func utcLocation(utc bool) *time.Location {
+	if utc {
+		return time.UTC
+	}
+	return time.Local
+}
+
+// Marks the end of a truncated message
+const truncatedMarker = "\u2026[truncated]"
+
+// SetMaxMessageLen limits the messages of the records the logger writes to n
+// bytes, truncating longer ones and ending them with "\u2026[truncated]" so that
+// they still fit.  Messages are only cut between runes.  Fields are not
+// counted.  An n of zero or less, the default, removes the limit; a filter's
+// own limit, see Filter.SetMaxMessageLen, takes precedence.  Close restores the
+// default.
+func (log Logger) SetMaxMessageLen(n int) {
+	log.setOptions(func(opts *loggerOptions) {
+		opts.maxMessageLen = n
+	})
+}
+
+// SetMaxMessageLen limits the messages of the records the filter writes to n
+// bytes (chainable), in place of its logger's limit.  See
+// Logger.SetMaxMessageLen.  An n of zero or less uses the logger's limit.
+func (filt *Filter) SetMaxMessageLen(n int) *Filter {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&filt.state().maxLen, int64(n))
+	return filt
+}
+
+// truncateMessage cuts msg to at most n bytes, marking where it was cut if
+// there is room to
+func truncateMessage(msg string, n int) string {
+	if n <= 0 || len(msg) <= n {
+		return msg
+	}
+	cut, marker := n, ""
+	if n > len(truncatedMarker) {
+		cut, marker = n-len(truncatedMarker), truncatedMarker
+	}
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut] + marker
+}
@@ -0,0 +1,39 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+// Package otel attaches OpenTelemetry trace context to log4go records.  It is
+// kept apart from log4go so that only programs which trace depend on
+// OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"sync"
+
+	"github.com/chespinoza/log4go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var registerOnce sync.Once
+
+// RegisterOTelFields makes log4go's LogCtx attach the IDs of the OpenTelemetry
+// span active in a context as the record fields "trace_id" and "span_id", so
+// that log lines can be matched up with traces.  Both are omitted when the
+// context has no valid span.  Calling it again does nothing.  Typically called
+// during initialization.
+func RegisterOTelFields() {
+	registerOnce.Do(func() {
+		log4go.RegisterContextFunc(spanFields)
+	})
+}
+
+// spanFields returns the trace and span IDs of the span in ctx, if any
+func spanFields(ctx context.Context) map[string]interface{} {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return map[string]interface{}{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}
+}
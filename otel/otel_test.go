@@ -0,0 +1,37 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chespinoza/log4go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRegisterOTelFields(t *testing.T) {
+	RegisterOTelFields()
+
+	mw := log4go.NewMemoryLogWriter(10).SetFormat("%M")
+	l := make(log4go.Logger).AddFilter("memory", log4go.FINEST, mw)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	l.LogCtx(trace.ContextWithSpanContext(context.Background(), sc), log4go.INFO, "traced")
+	l.LogCtx(context.Background(), log4go.INFO, "untraced")
+
+	lines := mw.Dump()
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, found %q", lines)
+	}
+	if want := "traced span_id=00f067aa0ba902b7 trace_id=4bf92f3577b34da6a3ce929d0e0e4736"; lines[0] != want {
+		t.Errorf("Expected %q, found %q", want, lines[0])
+	}
+	if want := "untraced"; lines[1] != want {
+		t.Errorf("Expected %q, found %q", want, lines[1])
+	}
+}
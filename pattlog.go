@@ -4,19 +4,37 @@ package log4go
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	FORMAT_DEFAULT = "[%D %T] [%L] (%S) %M"
 	FORMAT_SHORT   = "[%t %d] [%L] %M"
 	FORMAT_ABBREV  = "[%L] %M"
+
+	// FORMAT_LOGFMT is not a pattern: it selects the logfmt layout, e.g.
+	//   time=2009-02-13T23:31:30Z level=EROR source=main.go:12 msg="the message" key=value
+	FORMAT_LOGFMT = "logfmt"
+
+	// FORMAT_JSON is not a pattern: it selects one JSON object per record,
+	// see FormatJSON.
+	FORMAT_JSON = "json"
 )
 
 type formatCacheType struct {
 	LastUpdateSeconds    int64
+	loc                  *time.Location
 	shortTime, shortDate string
 	longTime, longDate   string
 }
@@ -24,36 +42,330 @@ type formatCacheType struct {
 var formatCache = &formatCacheType{}
 var formatMutex sync.Mutex
 
+// Set once a format using %g has been seen, so that records carry the ID of
+// the goroutine which logged them
+var wantGoroutineID int32
+
+// The process ID for %P
+var processID = strconv.Itoa(os.Getpid())
+
+// The host name for %H, looked up the first time it is needed
+var (
+	hostnameOnce sync.Once
+	hostnameStr  string
+)
+
+// hostname returns the host name, or "?" if it cannot be found
+func hostname() string {
+	hostnameOnce.Do(func() {
+		name, err := os.Hostname()
+		if err != nil || len(name) == 0 {
+			name = "?"
+		}
+		hostnameStr = name
+	})
+	return hostnameStr
+}
+
+// A formatToken is a piece of a compiled format: either literal text, or a
+// verb with its precision
+type formatToken struct {
+	verb byte
+	prec int
+	text string
+}
+
+// A compiledFormat is a format parsed into the tokens to render, in order.  The
+// last token is the newline ending the record.
+type compiledFormat []formatToken
+
+// The formats seen so far, compiled, up to maxCompiledFormats of them so that
+// formats built on the fly do not fill memory
+var (
+	compiledFormats    sync.Map // map[string]compiledFormat
+	compiledCount      int32
+	maxCompiledFormats int32 = 256
+)
+
+// compileFormat parses a format into the tokens to render
+func compileFormat(format string) compiledFormat {
+	var tokens compiledFormat
+	literal := func(text string) {
+		if n := len(tokens); n > 0 && tokens[n-1].verb == 0 {
+			tokens[n-1].text += text
+		} else if len(text) > 0 {
+			tokens = append(tokens, formatToken{text: text})
+		}
+	}
+
+	for i := 0; i < len(format); {
+		j := strings.IndexByte(format[i:], '%')
+		if j < 0 {
+			literal(format[i:])
+			break
+		}
+		literal(format[i : i+j])
+		i += j + 1
+
+		// "%%" is a literal %, as is a lone % at the end
+		if i == len(format) || format[i] == '%' {
+			literal("%")
+			i++
+			continue
+		}
+		verb := format[i]
+		i++
+
+		// A precision selects fractional seconds for %T and %w, e.g. %.3T
+		prec := 0
+		if verb == '.' && i+1 < len(format) && format[i] >= '1' && format[i] <= '9' && (format[i+1] == 'T' || format[i+1] == 'w') {
+			prec, verb = int(format[i]-'0'), format[i+1]
+			i += 2
+		}
+
+		// Unknown verbs are ignored
+		switch verb {
+		case 'T', 'w', 'z', 'Z', 't', 'D', 'd', 'I', 'E', 'L', 'S', 'F', 's', 'M', 'g', 'P', 'H', 'l', 'n':
+			tokens = append(tokens, formatToken{verb: verb, prec: prec})
+		}
+	}
+	literal("\n")
+	return tokens
+}
+
+// lookupFormat returns the compiled format, compiling it the first time it is
+// seen
+func lookupFormat(format string) compiledFormat {
+	if cf, ok := compiledFormats.Load(format); ok {
+		return cf.(compiledFormat)
+	}
+	cf := compileFormat(format)
+	if atomic.AddInt32(&compiledCount, 1) <= maxCompiledFormats {
+		compiledFormats.Store(format, cf)
+	}
+	return cf
+}
+
+// noteFormat prepares for records to be rendered with the given format,
+// compiling it once rather than for every record
+func noteFormat(format string) {
+	if len(format) == 0 || format == FORMAT_LOGFMT || format == FORMAT_JSON {
+		return
+	}
+	for _, tok := range lookupFormat(format) {
+		if tok.verb == 'g' {
+			atomic.StoreInt32(&wantGoroutineID, 1)
+		}
+	}
+}
+
+// writeFields appends the fields as " key=value" pairs, sorted by key
+func writeFields(out *bytes.Buffer, fields map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	for _, k := range sortedFieldKeys(fields) {
+		fmt.Fprintf(out, " %s=%v", k, fields[k])
+	}
+}
+
+// sortedFieldKeys returns the keys of fields in sorted order
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeLogfmtPair appends key=value, quoting the value if it is empty or
+// contains spaces, quotes, equals signs or control characters
+func writeLogfmtPair(out *bytes.Buffer, key, value string) {
+	if out.Len() > 0 {
+		out.WriteByte(' ')
+	}
+	out.WriteString(key)
+	out.WriteByte('=')
+	if value == "" || strings.IndexFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f || !utf8.ValidRune(r)
+	}) >= 0 {
+		out.WriteString(strconv.Quote(value))
+		return
+	}
+	out.WriteString(value)
+}
+
+// FormatLogfmt renders a record in the logfmt layout: time, level, source and
+// msg pairs followed by the record's fields in sorted order.
+func FormatLogfmt(rec *LogRecord) string {
+	if rec == nil {
+		return "<nil>"
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, 128))
+	writeLogfmtPair(out, "time", rec.Created.Format(time.RFC3339))
+	writeLogfmtPair(out, "level", levelStrings[rec.Level])
+	writeLogfmtPair(out, "source", rec.Source)
+	writeLogfmtPair(out, "msg", rec.Message)
+	for _, k := range sortedFieldKeys(rec.Fields) {
+		writeLogfmtPair(out, k, fmt.Sprint(rec.Fields[k]))
+	}
+	out.WriteByte('\n')
+
+	return out.String()
+}
+
+// The layout of a record rendered by FormatJSON
+type jsonRecord struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Source  string                 `json:"source"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// FormatJSON renders a record as a single line JSON object with time
+// (RFC 3339), level (e.g. "ERROR"), source and message keys, plus a fields
+// object if the record has fields.  Field values which cannot be encoded as
+// JSON are written as strings.
+func FormatJSON(rec *LogRecord) string {
+	if rec == nil {
+		return "<nil>"
+	}
+
+	jr := jsonRecord{
+		Time:    rec.Created.Format(time.RFC3339Nano),
+		Level:   rec.Level.String(),
+		Source:  rec.Source,
+		Message: rec.Message,
+		Fields:  rec.Fields,
+	}
+	js, err := json.Marshal(jr)
+	if err != nil {
+		jr.Fields = jsonFields(rec.Fields)
+		js, _ = json.Marshal(jr)
+	}
+
+	return string(js) + "\n"
+}
+
+// jsonFields returns a copy of fields in which the values which cannot be
+// encoded as JSON, such as NaN or a channel, are replaced by strings
+func jsonFields(fields map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if _, err := json.Marshal(v); err != nil {
+			v = fmt.Sprint(v)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// jsonRecords returns copies of the records with fields, in which the values
+// which cannot be encoded as JSON are replaced by strings
+func jsonRecords(recs ...*LogRecord) []*LogRecord {
+	out := make([]*LogRecord, len(recs))
+	for i, rec := range recs {
+		if len(rec.Fields) > 0 {
+			cp := *rec
+			cp.pooled = false
+			cp.Fields = jsonFields(rec.Fields)
+			rec = &cp
+		}
+		out[i] = rec
+	}
+	return out
+}
+
+// sourceFunc trims a "path/to/pkg.Func:line" source down to "pkg.Func"
+func sourceFunc(src string) string {
+	if i := strings.LastIndex(src, ":"); i >= 0 {
+		src = src[:i]
+	}
+	if i := strings.LastIndex(src, "/"); i >= 0 {
+		src = src[i+1:]
+	}
+	return src
+}
+
 // Known format codes:
 // %T - Time (15:04:05 MST)
+// %.3T - Time with fractional seconds, 1 to 9 digits (15:04:05.000 MST)
+// %w - Time the record was formatted for writing, as for %T (with %T, shows queueing delay)
+// %.3w - As %w with fractional seconds, 1 to 9 digits
 // %t - Time (15:04)
+// %z - Numeric time zone offset (-0700)
+// %Z - Time zone abbreviation (MST)
 // %D - Date (2006/01/02)
+// %I - Date and time in RFC 3339 format (2006-01-02T15:04:05.999999999Z07:00)
+// %E - Milliseconds since the Unix epoch
 // %d - Date (01/02/06)
 // %L - Level (FNST, FINE, DEBG, TRAC, WARN, EROR, CRIT)
-// %S - Source
-// %M - Message
+// %l - Level as one letter (N, F, D, T, I, W, E, C)
+// %n - Level as a number (0 for FINEST up to 7 for CRITICAL)
+// %S - Source, or "?" if it is not known
+// %F - Function name of the source (pkg.Func)
+// %s - Short file name and line of the source (file.go:123)
+// %M - Message, followed by any fields as sorted key=value pairs
+// %g - ID of the goroutine which logged the message (see below)
+// %P - ID of the process
+// %H - Host name, or "?" if it is not known
+// %% - A literal %, as is a lone % at the end of the format
 // Ignores unknown formats
 // Recommended: "[%D %T] [%L] (%S) %M"
+// The format FORMAT_LOGFMT renders the record with FormatLogfmt instead, and
+// FORMAT_JSON with FormatJSON.
+// The goroutine ID for %g is parsed from a stack trace as each record is
+// logged, which is only done once a writer's format uses %g; until then, %g
+// renders as "?".
 func FormatLogRecord(format string, rec *LogRecord) string {
+	return formatLogRecord(format, rec, "")
+}
+
+// withLineSeparator replaces the newline terminating a formatted record with
+// sep, unless sep is empty.
+func withLineSeparator(line, sep string) string {
+	if len(sep) == 0 || !strings.HasSuffix(line, "\n") {
+		return line
+	}
+	return line[:len(line)-1] + sep
+}
+
+// formatLogRecord is FormatLogRecord with %T rendered using timeFormat, a time
+// layout, if it is not empty.
+func formatLogRecord(format string, rec *LogRecord, timeFormat string) string {
 	if rec == nil {
 		return "<nil>"
 	}
 	if len(format) == 0 {
 		return ""
 	}
+	if format == FORMAT_LOGFMT {
+		return FormatLogfmt(rec)
+	}
+	if format == FORMAT_JSON {
+		return FormatJSON(rec)
+	}
+	return lookupFormat(format).render(rec, timeFormat)
+}
 
+// render formats a record as for formatLogRecord
+func (cf compiledFormat) render(rec *LogRecord, timeFormat string) string {
 	out := bytes.NewBuffer(make([]byte, 0, 64))
 	secs := rec.Created.UnixNano() / 1e9
 
 	formatMutex.Lock()
 	cache := *formatCache
 	formatMutex.Unlock()
-	if cache.LastUpdateSeconds != secs {
+	if cache.LastUpdateSeconds != secs || cache.loc != rec.Created.Location() {
 		month, day, year := rec.Created.Month(), rec.Created.Day(), rec.Created.Year()
 		hour, minute, second := rec.Created.Hour(), rec.Created.Minute(), rec.Created.Second()
 		zone, _ := rec.Created.Zone()
 		updated := &formatCacheType{
 			LastUpdateSeconds: secs,
+			loc:               rec.Created.Location(),
 			shortTime:         fmt.Sprintf("%02d:%02d", hour, minute),
 			shortDate:         fmt.Sprintf("%02d/%02d/%02d", day, month, year%100),
 			longTime:          fmt.Sprintf("%02d:%02d:%02d %s", hour, minute, second, zone),
@@ -65,36 +377,78 @@ func FormatLogRecord(format string, rec *LogRecord) string {
 		formatMutex.Unlock()
 	}
 
-	// Split the string into pieces by % signs
-	pieces := bytes.Split([]byte(format), []byte{'%'})
-
-	// Iterate over the pieces, replacing known formats
-	for i, piece := range pieces {
-		if i > 0 && len(piece) > 0 {
-			switch piece[0] {
-			case 'T':
+	// Render the tokens, replacing known formats
+	for _, tok := range cf {
+		switch tok.verb {
+		case 0:
+			out.WriteString(tok.text)
+		case 'T':
+			switch {
+			case len(timeFormat) > 0:
+				out.WriteString(rec.Created.Format(timeFormat))
+			case tok.prec > 0:
+				out.WriteString(rec.Created.Format("15:04:05." + strings.Repeat("0", tok.prec) + " MST"))
+			default:
 				out.WriteString(cache.longTime)
-			case 't':
-				out.WriteString(cache.shortTime)
-			case 'D':
-				out.WriteString(cache.longDate)
-			case 'd':
-				out.WriteString(cache.shortDate)
-			case 'L':
-				out.WriteString(levelStrings[rec.Level])
-			case 'S':
+			}
+		case 'w':
+			written := time.Now().In(rec.Created.Location())
+			switch {
+			case len(timeFormat) > 0:
+				out.WriteString(written.Format(timeFormat))
+			case tok.prec > 0:
+				out.WriteString(written.Format("15:04:05." + strings.Repeat("0", tok.prec) + " MST"))
+			default:
+				out.WriteString(written.Format("15:04:05 MST"))
+			}
+		case 'z':
+			out.WriteString(rec.Created.Format("-0700"))
+		case 'Z':
+			out.WriteString(rec.Created.Format("MST"))
+		case 't':
+			out.WriteString(cache.shortTime)
+		case 'D':
+			out.WriteString(cache.longDate)
+		case 'd':
+			out.WriteString(cache.shortDate)
+		case 'I':
+			out.WriteString(rec.Created.Format(time.RFC3339Nano))
+		case 'E':
+			out.WriteString(strconv.FormatInt(rec.Created.UnixNano()/1e6, 10))
+		case 'L':
+			out.WriteString(levelStrings[rec.Level])
+		case 'l':
+			out.WriteString(levelLetters[rec.Level])
+		case 'n':
+			out.WriteString(strconv.Itoa(int(rec.Level)))
+		case 'S':
+			if len(rec.Source) > 0 {
 				out.WriteString(rec.Source)
-			case 'M':
-				out.WriteString(rec.Message)
+			} else {
+				out.WriteByte('?')
 			}
-			if len(piece) > 1 {
-				out.Write(piece[1:])
+		case 'F':
+			out.WriteString(sourceFunc(rec.Source))
+		case 's':
+			if len(rec.file) > 0 {
+				out.WriteString(filepath.Base(rec.file))
 			}
-		} else if len(piece) > 0 {
-			out.Write(piece)
+		case 'M':
+			out.WriteString(rec.Message)
+			writeFields(out, rec.Fields)
+		case 'g':
+			if rec.goid == 0 {
+				atomic.StoreInt32(&wantGoroutineID, 1)
+				out.WriteByte('?')
+			} else {
+				out.WriteString(strconv.FormatInt(rec.goid, 10))
+			}
+		case 'P':
+			out.WriteString(processID)
+		case 'H':
+			out.WriteString(hostname())
 		}
 	}
-	out.WriteByte('\n')
 
 	return out.String()
 }
@@ -104,6 +458,7 @@ type FormatLogWriter chan *LogRecord
 
 // This creates a new FormatLogWriter
 func NewFormatLogWriter(out io.Writer, format string) FormatLogWriter {
+	noteFormat(format)
 	records := make(FormatLogWriter, LogBufferLength)
 	go records.run(out, format)
 	return records
@@ -123,6 +478,10 @@ func (w FormatLogWriter) LogWrite(rec *LogRecord) {
 
 // Close stops the logger from sending messages to standard output.  Attempts to
 // send log messages to this logger after a Close have undefined behavior.
+// Calling Close again does nothing.
 func (w FormatLogWriter) Close() {
+	defer func() {
+		recover() // already closed
+	}()
 	close(w)
 }
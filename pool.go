@@ -0,0 +1,65 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Records made by the logging methods are reused once every writer is done
+// with them: a doneOnReturn writer as soon as LogWrite returns, and a
+// recordReleaser when it calls release.  Any other writer may keep the
+// record, so a record given to one is never reused.
+var recordPool = sync.Pool{
+	New: func() interface{} { return new(LogRecord) },
+}
+
+// A doneOnReturn writer does not keep records once LogWrite returns
+type doneOnReturn interface {
+	doneOnReturn()
+}
+
+// A recordReleaser calls release on each record once it is done with it
+type recordReleaser interface {
+	releasesRecords()
+}
+
+func (NullLogWriter) doneOnReturn()        {}
+func (*MemoryLogWriter) doneOnReturn()     {}
+func (*FileLogWriter) releasesRecords()    {}
+func (*ConsoleLogWriter) releasesRecords() {}
+
+// newRecord returns a record from the pool set to r, held by the caller
+func newRecord(r LogRecord) *LogRecord {
+	rec := recordPool.Get().(*LogRecord)
+	*rec = r
+	rec.pooled, rec.refs = true, 1
+	return rec
+}
+
+// hold notes that rec is about to be given to w
+func (rec *LogRecord) hold(w LogWriter) {
+	if !rec.pooled {
+		return
+	}
+	switch w.(type) {
+	case doneOnReturn:
+	case recordReleaser:
+		atomic.AddInt32(&rec.refs, 1)
+	default:
+		rec.kept = true
+	}
+}
+
+// release notes that the caller is done with rec, returning it to the pool if
+// no one else holds it.
+func (rec *LogRecord) release() {
+	if !rec.pooled {
+		return
+	}
+	if atomic.AddInt32(&rec.refs, -1) == 0 && !rec.kept {
+		*rec = LogRecord{}
+		recordPool.Put(rec)
+	}
+}
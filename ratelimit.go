@@ -0,0 +1,259 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often a rate limited filter reports the records it dropped
+var rateLimitSummaryInterval = time.Second
+
+// A rateLimiter is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second.  Each record written takes a token.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	rate, burst float64
+	exempt      Level
+
+	tokens float64
+	last   time.Time
+
+	// Records dropped since the last summary, and the highest of their levels
+	dropped     int
+	droppedLvl  Level
+	lastSummary time.Time
+
+	// Records dropped since the filter was created
+	total int64
+}
+
+// allow reports whether a record may be written.  If records have been dropped
+// and a summary is due, the summary record is returned as well.
+func (rl *rateLimiter) allow(rec *LogRecord) (ok bool, summary *LogRecord) {
+	if rec.Level >= rl.exempt {
+		return true, nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+
+	if rl.tokens < 1 {
+		if rl.dropped == 0 || rec.Level > rl.droppedLvl {
+			rl.droppedLvl = rec.Level
+		}
+		rl.dropped++
+		rl.total++
+		return false, nil
+	}
+	rl.tokens--
+
+	if rl.dropped > 0 && now.Sub(rl.lastSummary) >= rateLimitSummaryInterval {
+		summary = rl.summary(now)
+	}
+	return true, summary
+}
+
+// summary returns a record reporting the dropped records, if there are any,
+// and starts counting again.  The caller must hold the lock.
+func (rl *rateLimiter) summary(now time.Time) *LogRecord {
+	if rl.dropped == 0 {
+		return nil
+	}
+	rec := &LogRecord{
+		Level:   rl.droppedLvl,
+		Created: now,
+		Source:  "log4go",
+		Message: fmt.Sprintf("dropped %d messages", rl.dropped),
+	}
+	rl.dropped = 0
+	rl.lastSummary = now
+	return rec
+}
+
+// SetRateLimit limits the filter to writing perSecond records per second on
+// average, with bursts of up to burst records (chainable).  Records beyond the
+// limit are dropped; at most once a second, the next record written is preceded
+// by a "dropped N messages" summary at the highest level dropped, and any
+// remaining count is reported when the filter is closed.  A perSecond of zero
+// or less removes the limit.  It is safe to call while other goroutines are
+// logging.
+func (filt *Filter) SetRateLimit(perSecond, burst int) *Filter {
+	var rl *rateLimiter
+	if perSecond > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		rl = &rateLimiter{
+			rate:   float64(perSecond),
+			burst:  float64(burst),
+			exempt: OFF,
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+	}
+
+	st := filt.state()
+	st.mu.Lock()
+	if rl != nil && st.limiter != nil {
+		rl.exempt = st.limiter.exempt
+	}
+	st.limiter = rl
+	st.mu.Unlock()
+	return filt
+}
+
+// SetRateLimitExempt lets records at or above lvl bypass the filter's rate
+// limit (chainable), e.g. so that CRITICAL records are never dropped.  Must be
+// called after SetRateLimit.
+func (filt *Filter) SetRateLimitExempt(lvl Level) *Filter {
+	st := filt.state()
+	st.mu.Lock()
+	if st.limiter != nil {
+		st.limiter.mu.Lock()
+		st.limiter.exempt = lvl
+		st.limiter.mu.Unlock()
+	}
+	st.mu.Unlock()
+	return filt
+}
+
+// SetSampleRate makes the filter pass only every nth record it matches,
+// starting with the first, and drop the rest (chainable).  Unlike a rate limit
+// this is deterministic and does not depend on timing.  CRITICAL records are
+// never dropped.  An n of 1 or less passes every record.  It is safe to call
+// while other goroutines are logging.
+func (filt *Filter) SetSampleRate(n int) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt64(&filt.state().sampleRate, int64(n))
+	return filt
+}
+
+// sample reports whether a record is one of those kept by the sample rate
+func (filt *Filter) sample(rec *LogRecord) bool {
+	st := filt.state()
+	n := atomic.LoadInt64(&st.sampleRate)
+	if n <= 1 || rec.Level >= CRITICAL {
+		return true
+	}
+	return (atomic.AddUint64(&st.sampled, 1)-1)%uint64(n) == 0
+}
+
+// SetMessageFilter makes the filter write only the records whose messages
+// match include, unless include is nil, and do not match exclude, unless
+// exclude is nil (chainable), e.g. to drop health check spam.  Records filtered
+// out this way do not count towards the sample rate or rate limit.  Passing
+// nil for both writes every record again.  It is safe to call while other
+// goroutines are logging.
+func (filt *Filter) SetMessageFilter(include, exclude *regexp.Regexp) *Filter {
+	st := filt.state()
+	st.mu.Lock()
+	st.include, st.exclude = include, exclude
+	st.mu.Unlock()
+	return filt
+}
+
+// write passes a record on to the filter's writer, subject to its message
+// filter, sample rate and rate limit.  A checked record is flushed, returning
+// any error.
+func (filt *Filter) write(rec *LogRecord) error {
+	st := filt.state()
+	if atomic.LoadInt32(&st.broken) != 0 {
+		return nil
+	}
+
+	st.mu.RLock()
+	rl, include, exclude := st.limiter, st.include, st.exclude
+	st.mu.RUnlock()
+
+	if include != nil && !include.MatchString(rec.Message) {
+		return nil
+	}
+	if exclude != nil && exclude.MatchString(rec.Message) {
+		return nil
+	}
+	if !filt.sample(rec) {
+		return nil
+	}
+
+	if rl != nil {
+		ok, summary := rl.allow(rec)
+		if summary != nil {
+			if err := filt.logWrite(summary); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			return nil
+		}
+	}
+	// The record may be released once written, so look at it first
+	checked := rec.checked
+	rec.hold(filt.LogWriter)
+	if err := filt.logWrite(rec); err != nil {
+		return err
+	}
+	if f, ok := filt.LogWriter.(Flusher); ok && checked {
+		return f.Flush()
+	}
+	return nil
+}
+
+// logWrite gives a record to the filter's writer.  If the writer panics, the
+// panic is reported on stderr and returned as an error, and the writer is
+// disabled so that one bad writer cannot take down the program.
+func (filt *Filter) logWrite(rec *LogRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.StoreInt32(&filt.state().broken, 1)
+			err = fmt.Errorf("panic: %v", r)
+			fmt.Fprintf(os.Stderr, "Filter: %s writer disabled after LogWrite %s\n", writerType(filt.LogWriter), err)
+		}
+	}()
+	filt.LogWrite(rec)
+	return nil
+}
+
+// Close reports any records dropped by the rate limit, then closes the
+// filter's writer, once any records being written to it are done.  A writer
+// disabled by a panic is closed too, but any further panic is ignored.
+func (filt *Filter) Close() {
+	st := filt.state()
+	defer filterStates.Delete(filt)
+	st.writing.Lock()
+	defer st.writing.Unlock()
+	st.closed = true
+	if atomic.LoadInt32(&st.broken) != 0 {
+		safely(filt.LogWriter.Close)
+		return
+	}
+
+	st.mu.RLock()
+	rl := st.limiter
+	st.mu.RUnlock()
+
+	if rl != nil {
+		rl.mu.Lock()
+		summary := rl.summary(time.Now())
+		rl.mu.Unlock()
+		if summary != nil {
+			filt.LogWrite(summary)
+		}
+	}
+	filt.LogWriter.Close()
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"regexp"
+)
+
+// A redactor replaces the matches of a pattern in messages
+type redactor struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// AddRedactor makes the logger replace every match of re in the message of
+// each record it dispatches with replacement, e.g. to mask secrets which might
+// otherwise be written.  The replacement is expanded as for
+// regexp.ReplaceAllString, so it may refer to submatches with $1 and so on.
+// Redactors are applied in the order they were added, once for each record
+// before it is passed to any hook or writer.  Fields are not redacted.
+// Loading a configuration keeps the redactors; Close removes them.
+func (log Logger) AddRedactor(re *regexp.Regexp, replacement string) {
+	log.setOptions(func(opts *loggerOptions) {
+		// Copy, as records may be being redacted with the old ones
+		redactors := make([]redactor, len(opts.redactors), len(opts.redactors)+1)
+		copy(redactors, opts.redactors)
+		opts.redactors = append(redactors, redactor{re, replacement})
+	})
+}
+
+// redact applies the logger's redactors to the message of rec
+func (opts *loggerOptions) redact(rec *LogRecord) {
+	for _, r := range opts.redactors {
+		rec.Message = r.re.ReplaceAllString(rec.Message, r.replacement)
+	}
+}
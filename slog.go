@@ -0,0 +1,131 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// slogHandler routes log/slog records through a Logger
+type slogHandler struct {
+	logger Logger
+	fields map[string]interface{} // attributes added by WithAttrs
+	prefix string                 // group prefix for new attributes
+}
+
+// NewSlogHandler returns a slog.Handler which sends records to the filters of
+// the given Logger.  Slog levels are mapped onto the closest log4go level and
+// attributes become record fields, with group names joined to their keys by
+// dots.
+func NewSlogHandler(logger Logger) slog.Handler {
+	return &slogHandler{logger: logger}
+}
+
+// slogLevel maps a slog level onto a log4go level
+func slogLevel(l slog.Level) Level {
+	switch {
+	case l < slog.LevelDebug:
+		return FINE
+	case l < slog.LevelInfo:
+		return DEBUG
+	case l < slog.LevelWarn:
+		return INFO
+	case l < slog.LevelError:
+		return WARNING
+	case l < slog.LevelError+4:
+		return ERROR
+	}
+	return CRITICAL
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return !h.logger.skip(slogLevel(l))
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	lvl := slogLevel(r.Level)
+	if h.logger.skip(lvl) {
+		return nil
+	}
+
+	rec := &LogRecord{
+		Level:   lvl,
+		Created: r.Time,
+		Message: r.Message,
+	}
+	if rec.Created.IsZero() {
+		rec.Created = time.Now()
+	}
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		rec.Source = fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+		rec.file = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+	}
+
+	if len(h.fields) > 0 || r.NumAttrs() > 0 {
+		rec.Fields = make(map[string]interface{}, len(h.fields)+r.NumAttrs())
+		for k, v := range h.fields {
+			rec.Fields[k] = v
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addSlogAttr(rec.Fields, h.prefix, a)
+			return true
+		})
+	}
+
+	h.logger.dispatch(rec)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := &slogHandler{
+		logger: h.logger,
+		fields: make(map[string]interface{}, len(h.fields)+len(attrs)),
+		prefix: h.prefix,
+	}
+	for k, v := range h.fields {
+		h2.fields[k] = v
+	}
+	for _, a := range attrs {
+		addSlogAttr(h2.fields, h.prefix, a)
+	}
+	return h2
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{
+		logger: h.logger,
+		fields: h.fields,
+		prefix: h.prefix + name + ".",
+	}
+}
+
+// addSlogAttr flattens an attribute into fields, following the slog rules
+// for empty keys and groups
+func addSlogAttr(fields map[string]interface{}, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addSlogAttr(fields, prefix, ga)
+		}
+		return
+	}
+	if strings.TrimSpace(a.Key) == "" {
+		return
+	}
+	fields[prefix+a.Key] = a.Value.Any()
+}
@@ -3,55 +3,481 @@
 package log4go
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// This log writer sends output to a socket
-type SocketLogWriter chan *LogRecord
+var (
+	// SocketBufferLength specifies how many log records a reconnecting
+	// SocketLogWriter holds on to while it is disconnected.  Records arriving
+	// once the buffer is full are dropped.
+	SocketBufferLength = 1024
 
-// This is the SocketLogWriter's output method
-func (w SocketLogWriter) LogWrite(rec *LogRecord) {
-	w <- rec
+	// Bounds for the delay between reconnection attempts
+	socketMinBackoff = 100 * time.Millisecond
+	socketMaxBackoff = 30 * time.Second
+)
+
+// The largest batch sent over UDP: an Ethernet MTU of 1500 bytes less the IPv6
+// and UDP headers, so that datagrams are not fragmented
+const maxUDPBatch = 1452
+
+// What a SocketLogWriter does with a record when its queue is full
+type OverflowPolicy int
+
+const (
+	Block      OverflowPolicy = iota // Wait for room in the queue
+	DropNewest                       // Drop the record being logged
+	DropOldest                       // Drop the oldest queued record to make room
+)
+
+// A socketPayload is what is written to the socket at once: a record, or a
+// batch of them
+type socketPayload struct {
+	data    []byte
+	records int
 }
 
-func (w SocketLogWriter) Close() {
-	close(w)
+// This log writer sends output to a socket.  It is created with
+// NewSocketLogWriter; unlike in 3.0.2, it is no longer a channel which can be
+// made directly.
+type SocketLogWriter struct {
+	rec  chan *LogRecord
+	done chan bool
+
+	startOnce, closeOnce sync.Once
+
+	// What to do when the queue is full
+	overflow OverflowPolicy
+
+	// Format of each record; the LogRecord as JSON if empty
+	format string
+
+	// The connection and where it goes
+	proto, hostport string
+	sock            net.Conn
+	dial            func() (net.Conn, error)
+
+	// Set once the endpoint has been reached
+	connected bool
+
+	// Set while the endpoint cannot be reached or written to
+	failing int32
+
+	// Redial the endpoint after a failed write
+	reconnect bool
+	backoff   time.Duration
+	nextDial  time.Time
+
+	// Records waiting for the connection to come back
+	pending []socketPayload
+	dropped int64
+
+	// Batch records into writes of up to batchBytes, sent at least every
+	// batchFlush
+	batchBytes  int
+	batchFlush  time.Duration
+	batchTicker *time.Ticker
+	batch       []byte
+	batched     int
+
+	// Records sent, and records lost to errors
+	written, errored int64
+}
+
+// This is the SocketLogWriter's output method.  When the queue is full it
+// blocks, or drops a record, according to the overflow policy.
+func (w *SocketLogWriter) LogWrite(rec *LogRecord) {
+	w.startOnce.Do(w.start)
+
+	switch w.overflow {
+	case DropNewest:
+		select {
+		case w.rec <- rec:
+		default:
+			atomic.AddInt64(&w.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case w.rec <- rec:
+				return
+			default:
+			}
+
+			// The queue is full, so make room by dropping the oldest record
+			select {
+			case <-w.rec:
+				atomic.AddInt64(&w.dropped, 1)
+			default:
+			}
+		}
+	default:
+		w.rec <- rec
+	}
 }
 
-func NewSocketLogWriter(proto, hostport string) SocketLogWriter {
-	sock, err := net.Dial(proto, hostport)
+// Close stops the writer, waiting for any pending records to be sent.  Calling
+// it again does nothing.
+func (w *SocketLogWriter) Close() {
+	w.closeOnce.Do(func() {
+		w.startOnce.Do(w.start)
+		close(w.rec)
+		<-w.done
+	})
+}
+
+// NewSocketLogWriter creates a new LogWriter which sends records as JSON (see
+// SetFormat) over the given protocol, which is passed straight to net.Dial.
+// For the "unix" and "unixgram" protocols the hostport is the path of the
+// socket file; with reconnection enabled a socket file which disappears is
+// redialed until it comes back.  If the endpoint cannot be reached yet, e.g.
+// because the collector is still starting, the connection is retried as
+// records are written, backing off between attempts, and up to
+// SocketBufferLength records are held until it succeeds.
+func NewSocketLogWriter(proto, hostport string) *SocketLogWriter {
+	w, err := newSocketLogWriter(proto, hostport, func() (net.Conn, error) {
+		return net.Dial(proto, hostport)
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "NewSocketLogWriter(%q): %s\n", hostport, err)
-		return nil
+		fmt.Fprintf(os.Stderr, "NewSocketLogWriter(%q): %s (will retry)\n", hostport, err)
 	}
+	return w
+}
+
+// NewTLSSocketLogWriter creates a SocketLogWriter which sends its records over
+// a TLS connection.  The proto must be a stream protocol such as "tcp" ("tls"
+// is accepted as a synonym).  An error is returned if the connection or the
+// TLS handshake fails.
+func NewTLSSocketLogWriter(proto, hostport string, cfg *tls.Config) (*SocketLogWriter, error) {
+	switch proto {
+	case "tls":
+		proto = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("NewTLSSocketLogWriter(%q): TLS is not supported over %q", hostport, proto)
+	}
+	w, err := newSocketLogWriter(proto, hostport, func() (net.Conn, error) {
+		return tls.Dial(proto, hostport, cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
 
-	w := SocketLogWriter(make(chan *LogRecord, LogBufferLength))
+// newSocketLogWriter creates a SocketLogWriter and dials its endpoint.  If the
+// dial fails, the error is returned along with a writer which dials again when
+// records are written.
+func newSocketLogWriter(proto, hostport string, dial func() (net.Conn, error)) (*SocketLogWriter, error) {
+	sock, err := dial()
 
+	w := &SocketLogWriter{
+		rec:       make(chan *LogRecord, LogBufferLength),
+		done:      make(chan bool),
+		proto:     proto,
+		hostport:  hostport,
+		sock:      sock,
+		dial:      dial,
+		connected: err == nil,
+	}
+	if err != nil {
+		w.failing = 1
+	}
+	return w, err
+}
+
+// start starts the goroutine which sends the records.  It is started with the
+// first record, so that the writer can be set up until then.
+func (w *SocketLogWriter) start() {
 	go func() {
 		defer func() {
-			if sock != nil && proto == "tcp" {
-				sock.Close()
+			if w.batchTicker != nil {
+				w.batchTicker.Stop()
 			}
+			if err := w.sendBatch(); err != nil {
+				fmt.Fprintf(os.Stderr, "SocketLogWriter(%q): %s\n", w.hostport, err)
+			}
+			if w.sock != nil {
+				w.sock.Close()
+			}
+			close(w.done)
 		}()
 
-		for rec := range w {
-			// Marshall into JSON
-			js, err := json.Marshal(rec)
-			if err != nil {
-				fmt.Fprint(os.Stderr, "SocketLogWriter(%q): %s", hostport, err)
-				return
+		for {
+			var flush <-chan time.Time
+			if w.batchTicker != nil {
+				flush = w.batchTicker.C
 			}
 
-			_, err = sock.Write(js)
-			if err != nil {
-				fmt.Fprint(os.Stderr, "SocketLogWriter(%q): %s", hostport, err)
-				return
+			select {
+			case <-flush:
+				if err := w.sendBatch(); err != nil {
+					fmt.Fprintf(os.Stderr, "SocketLogWriter(%q): %s\n", w.hostport, err)
+					return
+				}
+			case rec, ok := <-w.rec:
+				if !ok {
+					return
+				}
+
+				// A record which cannot be encoded is dropped, not the writer
+				js, err := w.encode(rec)
+				if err != nil {
+					atomic.AddInt64(&w.errored, 1)
+					fmt.Fprintf(os.Stderr, "SocketLogWriter(%q): %s\n", w.hostport, err)
+					continue
+				}
+
+				if w.batchBytes > 0 {
+					err = w.addToBatch(js)
+				} else {
+					err = w.write(socketPayload{js, 1})
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "SocketLogWriter(%q): %s\n", w.hostport, err)
+					return
+				}
 			}
 		}
 	}()
+}
+
+// encode renders a record as it is sent.  Fields which cannot be encoded as
+// JSON are sent as strings.
+func (w *SocketLogWriter) encode(rec *LogRecord) ([]byte, error) {
+	if len(w.format) > 0 {
+		return []byte(FormatLogRecord(w.format, rec)), nil
+	}
+
+	// Marshall into JSON
+	js, err := json.Marshal(rec)
+	if err != nil && len(rec.Fields) > 0 {
+		js, err = json.Marshal(jsonRecords(rec)[0])
+	}
+	return js, err
+}
+
+// addToBatch adds a record to the batch, first sending the batch if the record
+// would take it past the limit.  A record which is larger than the limit on
+// its own is sent alone.
+func (w *SocketLogWriter) addToBatch(js []byte) error {
+	// Start the flush ticker once batching has been asked for
+	if w.batchTicker == nil && w.batchFlush > 0 {
+		w.batchTicker = time.NewTicker(w.batchFlush)
+	}
+
+	if w.batched > 0 && len(w.batch)+len(js)+1 > w.batchBytes {
+		if err := w.sendBatch(); err != nil {
+			return err
+		}
+	}
+	w.batch = append(w.batch, js...)
+	if !bytes.HasSuffix(js, []byte{'\n'}) {
+		w.batch = append(w.batch, '\n')
+	}
+	w.batched++
+	if len(w.batch) >= w.batchBytes {
+		return w.sendBatch()
+	}
+	return nil
+}
+
+// sendBatch writes the batched records, if there are any
+func (w *SocketLogWriter) sendBatch() error {
+	if w.batched == 0 {
+		return nil
+	}
+	p := socketPayload{w.batch, w.batched}
+	w.batch, w.batched = nil, 0
+	return w.write(p)
+}
+
+// write writes a record or a batch of them.  Without reconnection, a failed
+// write is returned as an error once the endpoint has been reached; until then
+// records are buffered while the connection is retried.
+func (w *SocketLogWriter) write(p socketPayload) error {
+	if w.reconnect || !w.connected {
+		w.send(p)
+		return nil
+	}
+	if _, err := w.sock.Write(p.data); err != nil {
+		atomic.AddInt64(&w.errored, int64(p.records))
+		atomic.StoreInt32(&w.failing, 1)
+		return err
+	}
+	atomic.AddInt64(&w.written, int64(p.records))
+	return nil
+}
+
+// send writes a payload when reconnection is enabled, first flushing anything
+// buffered while the connection was down.  If the connection is unavailable
+// the payload is buffered instead.
+func (w *SocketLogWriter) send(p socketPayload) {
+	if w.sock == nil && !w.redial() {
+		w.buffer(p)
+		return
+	}
+
+	for len(w.pending) > 0 {
+		if _, err := w.sock.Write(w.pending[0].data); err != nil {
+			w.disconnect(err)
+			w.buffer(p)
+			return
+		}
+		atomic.AddInt64(&w.written, int64(w.pending[0].records))
+		w.pending = w.pending[1:]
+	}
+
+	if _, err := w.sock.Write(p.data); err != nil {
+		w.disconnect(err)
+		w.buffer(p)
+		return
+	}
+	atomic.AddInt64(&w.written, int64(p.records))
+	atomic.StoreInt32(&w.failing, 0)
+	w.connected = true
+}
+
+// redial tries to reestablish the connection, backing off exponentially
+// between failed attempts.
+func (w *SocketLogWriter) redial() bool {
+	if time.Now().Before(w.nextDial) {
+		return false
+	}
+
+	sock, err := w.dial()
+	if err != nil {
+		atomic.StoreInt32(&w.failing, 1)
+		if w.backoff < socketMinBackoff {
+			w.backoff = socketMinBackoff
+		} else if w.backoff *= 2; w.backoff > socketMaxBackoff {
+			w.backoff = socketMaxBackoff
+		}
+		w.nextDial = time.Now().Add(w.backoff)
+		return false
+	}
+
+	w.sock = sock
+	w.backoff = 0
+	return true
+}
+
+// disconnect drops a connection which failed to write
+func (w *SocketLogWriter) disconnect(err error) {
+	fmt.Fprintf(os.Stderr, "SocketLogWriter(%q): %s\n", w.hostport, err)
+	atomic.StoreInt32(&w.failing, 1)
+	w.sock.Close()
+	w.sock = nil
+}
+
+// buffer holds on to a payload until the connection is back
+func (w *SocketLogWriter) buffer(p socketPayload) {
+	if len(w.pending) >= SocketBufferLength {
+		atomic.AddInt64(&w.dropped, int64(p.records))
+		return
+	}
+	w.pending = append(w.pending, p)
+}
 
+// SetReconnect changes whether the writer redials its endpoint after a failed
+// write (chainable).  While disconnected, up to SocketBufferLength records are
+// buffered and sent once the connection is back; any beyond that are dropped.
+// Must be called before the first log message is written.
+func (w *SocketLogWriter) SetReconnect(reconnect bool) *SocketLogWriter {
+	w.reconnect = reconnect
 	return w
 }
+
+// SetBatch makes the writer send records in batches of up to maxBytes, one
+// record per line, rather than one write per record (chainable).  A batch is
+// sent once it is full and at least every flush, if flush is positive, and
+// when the writer is closed.  A record larger than maxBytes is sent on its own.
+// Over UDP, maxBytes is capped so that each batch fits in a single unfragmented
+// datagram.  A maxBytes of zero or less turns batching off.  Must be called
+// before the first log message is written.
+func (w *SocketLogWriter) SetBatch(maxBytes int, flush time.Duration) *SocketLogWriter {
+	switch w.proto {
+	case "udp", "udp4", "udp6":
+		if maxBytes > maxUDPBatch {
+			maxBytes = maxUDPBatch
+		}
+	}
+	w.batchBytes, w.batchFlush = maxBytes, flush
+	return w
+}
+
+// SetFormat changes how records are sent (chainable).  By default (an empty
+// format) each record is sent as the LogRecord marshalled into JSON.  Any other
+// format is rendered as for FileLogWriter.SetFormat, one record per line;
+// FORMAT_JSON sends newline-delimited JSON objects, see FormatJSON.  Must be
+// called before the first log message is written.
+func (w *SocketLogWriter) SetFormat(format string) *SocketLogWriter {
+	w.format = format
+	noteFormat(format)
+	return w
+}
+
+// SetJSON changes whether records are sent as newline-delimited JSON objects
+// as rendered by FormatJSON (chainable), i.e. SetFormat(FORMAT_JSON).  Turning
+// it off restores the default.  Must be called before the first log message is
+// written.
+func (w *SocketLogWriter) SetJSON(js bool) *SocketLogWriter {
+	if js {
+		return w.SetFormat(FORMAT_JSON)
+	}
+	return w.SetFormat("")
+}
+
+// SetQueueSize changes how many records may be queued for the writer's
+// goroutine to send (chainable), LogBufferLength by default.  What happens
+// when the queue is full is chosen with SetOverflow.  Must be called before
+// the first log message is written.
+func (w *SocketLogWriter) SetQueueSize(n int) *SocketLogWriter {
+	if n < 0 {
+		n = 0
+	}
+	w.rec = make(chan *LogRecord, n)
+	return w
+}
+
+// SetOverflow changes what LogWrite does when the queue is full (chainable):
+// Block, the default, waits for room; DropNewest drops the record being
+// logged; DropOldest drops the oldest queued record to make room for it.
+// Dropped records are counted in Dropped.  Must be called before the first
+// log message is written.
+func (w *SocketLogWriter) SetOverflow(policy OverflowPolicy) *SocketLogWriter {
+	w.overflow = policy
+	return w
+}
+
+// Stats returns the number of records sent, dropped because the queue or the
+// reconnect buffer was full, and lost to errors.
+func (w *SocketLogWriter) Stats() WriterStats {
+	return WriterStats{
+		Written: atomic.LoadInt64(&w.written),
+		Dropped: atomic.LoadInt64(&w.dropped),
+		Errors:  atomic.LoadInt64(&w.errored),
+	}
+}
+
+// Dropped returns the number of records discarded because the queue or the
+// reconnect buffer was full.
+func (w *SocketLogWriter) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// Healthy reports whether the writer is connected and its last write
+// succeeded.  A writer which has stopped after an error, or is waiting to
+// reconnect, is not healthy; one which was disconnected is only found to be so
+// when it next writes.
+func (w *SocketLogWriter) Healthy() bool {
+	return atomic.LoadInt32(&w.failing) == 0
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// WriterStats counts what has become of the records given to a writer
+type WriterStats struct {
+	Written int64 // records written out
+	Dropped int64 // records discarded without being written, e.g. by a full queue
+	Errors  int64 // records lost to write errors
+}
+
+// A StatsWriter is a LogWriter which counts the records it writes and loses
+type StatsWriter interface {
+	Stats() WriterStats
+}
+
+// Stats returns the counts for each filter, keyed by tag, whose writer is a
+// StatsWriter or which has a rate limit.  Records dropped by a filter's rate
+// limit are included in its Dropped count.
+func (log Logger) Stats() map[string]WriterStats {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+
+	stats := make(map[string]WriterStats)
+	for name, filt := range log {
+		st := filt.state()
+		st.mu.RLock()
+		rl := st.limiter
+		st.mu.RUnlock()
+
+		sw, ok := filt.LogWriter.(StatsWriter)
+		if !ok && rl == nil {
+			continue
+		}
+
+		var s WriterStats
+		if ok {
+			s = sw.Stats()
+		}
+		if rl != nil {
+			rl.mu.Lock()
+			s.Dropped += rl.total
+			rl.mu.Unlock()
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+// A HealthWriter is a LogWriter which can fail to deliver records, e.g. over a
+// network, and reports whether it is currently able to
+type HealthWriter interface {
+	Healthy() bool
+}
+
+// Unhealthy returns the sorted tags of the filters whose writers report that
+// they are not healthy (see HealthWriter), or were disabled after panicking,
+// e.g. for a readiness probe.  Writers which are not HealthWriters are assumed
+// to be healthy.
+func (log Logger) Unhealthy() []string {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+
+	var tags []string
+	for name, filt := range log {
+		hw, ok := filt.LogWriter.(HealthWriter)
+		if atomic.LoadInt32(&filt.state().broken) != 0 || ok && !hw.Healthy() {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"io"
+	stdlog "log"
+	"sync"
+	"time"
+)
+
+// A lineWriter turns whatever is written to it into log records, one per
+// line.  A trailing partial line is held until its newline arrives.
+type lineWriter struct {
+	log    Logger
+	source string
+	lvl    Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(string(bytes.TrimSuffix(w.buf[:i], []byte{'\r'})))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) logLine(line string) {
+	if w.log.skip(w.lvl) {
+		return
+	}
+	w.log.dispatch(&LogRecord{
+		Level:   w.lvl,
+		Created: time.Now(),
+		Source:  w.source,
+		Message: line,
+	})
+}
+
+// StdLogger returns a standard library *log.Logger which writes to this logger.
+// Each line of its output becomes a record at the given level, with tag as its
+// source; the standard logger's own prefix and flags are left empty since
+// log4go adds its own.  Use it to capture the output of packages which log
+// through a *log.Logger.
+func (log Logger) StdLogger(tag string, lvl Level) *stdlog.Logger {
+	return stdlog.New(log.Writer(tag, lvl), "", 0)
+}
+
+// Writer returns an io.Writer which writes to this logger, e.g. to capture the
+// standard error of a command.  Each line written becomes a record at the given
+// level, with tag as its source.  A trailing partial line is held until its
+// newline is written.  It is safe for concurrent use.
+func (log Logger) Writer(tag string, lvl Level) io.Writer {
+	return &lineWriter{log: log, source: tag, lvl: lvl}
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+
+package log4go
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+)
+
+// Syslog facilities by the names used in configuration files
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// This log writer sends output to the syslog daemon
+type SyslogLogWriter struct {
+	rec  chan *LogRecord
+	done chan bool
+
+	closeOnce sync.Once
+
+	sys    *syslog.Writer
+	format string
+}
+
+// NewSyslogLogWriter creates a new LogWriter which sends records to the local
+// syslog daemon with the given tag and facility.  Log levels are mapped onto
+// syslog severities: CRITICAL to crit, ERROR to err, WARNING to warning, INFO
+// to info and everything below to debug.
+func NewSyslogLogWriter(tag string, facility syslog.Priority) (*SyslogLogWriter, error) {
+	return newSyslogLogWriter("", "", tag, facility)
+}
+
+func newSyslogLogWriter(network, raddr, tag string, facility syslog.Priority) (*SyslogLogWriter, error) {
+	sys, err := syslog.Dial(network, raddr, facility, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &SyslogLogWriter{
+		rec:    make(chan *LogRecord, LogBufferLength),
+		done:   make(chan bool),
+		sys:    sys,
+		format: "(%S) %M",
+	}
+
+	go func() {
+		defer func() {
+			w.sys.Close()
+			close(w.done)
+		}()
+
+		for rec := range w.rec {
+			if err := w.write(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "SyslogLogWriter: %s\n", err)
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// write sends the record with the severity for its level
+func (w *SyslogLogWriter) write(rec *LogRecord) error {
+	msg := FormatLogRecord(w.format, rec)
+	switch {
+	case rec.Level >= CRITICAL:
+		return w.sys.Crit(msg)
+	case rec.Level >= ERROR:
+		return w.sys.Err(msg)
+	case rec.Level >= WARNING:
+		return w.sys.Warning(msg)
+	case rec.Level >= INFO:
+		return w.sys.Info(msg)
+	}
+	return w.sys.Debug(msg)
+}
+
+// This is the SyslogLogWriter's output method
+func (w *SyslogLogWriter) LogWrite(rec *LogRecord) {
+	w.rec <- rec
+}
+
+// Close stops the writer, waiting for any pending records to be sent before
+// closing the connection to the syslog daemon.  Calling it again does
+// nothing.
+func (w *SyslogLogWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.rec)
+		<-w.done
+	})
+}
+
+// Set the logging format (chainable).  The syslog daemon adds its own
+// timestamp, so the default is "(%S) %M".  Must be called before the first
+// log message is written.
+func (w *SyslogLogWriter) SetFormat(format string) *SyslogLogWriter {
+	w.format = format
+	noteFormat(format)
+	return w
+}
+
+func propsToSyslogLogWriter(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+	tag := ""
+	facility := syslog.LOG_USER
+
+	// Parse properties
+	for _, name := range sortedPropNames(props) {
+		value := props[name]
+		switch name {
+		case "tag":
+			tag = value
+		case "facility":
+			f, ok := syslogFacilities[value]
+			if !ok {
+				return nil, fmt.Errorf("LoadConfiguration: Error: Property \"%s\" for syslog filter has unknown value in %s: %s\n", "facility", filename, value)
+			}
+			facility = f
+		default:
+			configWarning("LoadConfiguration: Warning: Unknown property \"%s\" for syslog filter in %s\n", name, filename)
+		}
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !enabled {
+		return nil, nil
+	}
+
+	slw, err := NewSyslogLogWriter(tag, facility)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not connect syslog filter in %s: %s\n", filename, err)
+	}
+	return slw, nil
+}
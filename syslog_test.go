@@ -0,0 +1,79 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+
+package log4go
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake syslog daemon
+	addr := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	w, err := newSyslogLogWriter("unixgram", addr, "l4gtest", syslog.LOG_LOCAL3)
+	if err != nil {
+		t.Fatalf("newSyslogLogWriter: %s", err)
+	}
+
+	severities := map[Level]syslog.Priority{
+		CRITICAL: syslog.LOG_CRIT,
+		ERROR:    syslog.LOG_ERR,
+		WARNING:  syslog.LOG_WARNING,
+		INFO:     syslog.LOG_INFO,
+		TRACE:    syslog.LOG_DEBUG,
+		DEBUG:    syslog.LOG_DEBUG,
+		FINE:     syslog.LOG_DEBUG,
+		FINEST:   syslog.LOG_DEBUG,
+	}
+
+	buf := make([]byte, 1024)
+	for lvl := FINEST; lvl <= CRITICAL; lvl++ {
+		w.LogWrite(newLogRecord(lvl, "source", "message"))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read: %s", err)
+		}
+		msg := string(buf[:n])
+
+		end := strings.Index(msg, ">")
+		if !strings.HasPrefix(msg, "<") || end < 0 {
+			t.Fatalf("Malformed syslog message %q", msg)
+		}
+		pri, err := strconv.Atoi(msg[1:end])
+		if err != nil {
+			t.Fatalf("Malformed syslog priority in %q", msg)
+		}
+		if want := int(syslog.LOG_LOCAL3 | severities[lvl]); pri != want {
+			t.Errorf("%s: got priority %d, want %d", lvl, pri, want)
+		}
+		if !strings.Contains(msg, "l4gtest") || !strings.Contains(msg, "(source) message") {
+			t.Errorf("%s: unexpected message %q", lvl, msg)
+		}
+	}
+
+	w.Close()
+	w.Close() // does nothing
+}
@@ -0,0 +1,13 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build windows || plan9
+
+package log4go
+
+import (
+	"fmt"
+)
+
+func propsToSyslogLogWriter(filename string, props map[string]string, enabled bool) (LogWriter, error) {
+	return nil, fmt.Errorf("LoadConfiguration: Error: Could not load XML configuration in %s: syslog filters are not supported on this platform\n", filename)
+}
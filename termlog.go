@@ -3,45 +3,226 @@
 package log4go
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 )
 
 var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
 
-// This is the standard writer that prints to standard output.
-type ConsoleLogWriter chan *LogRecord
+// ANSI color escapes for each level, used when colors are enabled
+const colorReset = "\x1b[0m"
+
+var levelColors = [...]string{
+	FINEST:   "\x1b[90m", // gray
+	FINE:     "\x1b[90m", // gray
+	DEBUG:    "\x1b[90m", // gray
+	TRACE:    "\x1b[90m", // gray
+	INFO:     "\x1b[32m", // green
+	WARNING:  "\x1b[33m", // yellow
+	ERROR:    "\x1b[31m", // red
+	CRITICAL: "\x1b[31m", // red
+}
+
+// A levelWriter receives the records whose levels are in a band
+type levelWriter struct {
+	min, max Level
+	out      io.Writer
+	tty      bool
+}
+
+// This is the standard writer that prints to standard output.  It is created
+// with NewConsoleLogWriter; unlike in 3.0.2, it is no longer a channel which
+// can be made directly.
+type ConsoleLogWriter struct {
+	rec chan *LogRecord
+
+	closeOnce sync.Once
+
+	// Where WARNING and above go when the streams are split
+	errout io.Writer
+	split  bool
+
+	// Where records go by level, ahead of the streams above
+	bands []levelWriter
+
+	// Format of each record; the fixed console layout if empty
+	format string
+
+	// Where to render times, if not in the records' own location
+	loc *time.Location
+
+	// Terminates each record in place of "\n"
+	lineSep string
+
+	// Colorize the level
+	colors     bool
+	forceColor bool
+}
 
 // This creates a new ConsoleLogWriter
-func NewConsoleLogWriter() ConsoleLogWriter {
-	records := make(ConsoleLogWriter, LogBufferLength)
-	go records.run(stdout)
-	return records
+func NewConsoleLogWriter() *ConsoleLogWriter {
+	w := &ConsoleLogWriter{
+		rec:    make(chan *LogRecord, LogBufferLength),
+		errout: stderr,
+	}
+	go w.run(stdout)
+	return w
+}
+
+// NewConsoleLogWriterStream creates a ConsoleLogWriter which writes records
+// at WARNING and above to errw and the rest to out.  Splitting can be turned
+// off again with SetSplitStreams, in which case everything goes to out.
+func NewConsoleLogWriterStream(out, errw io.Writer) *ConsoleLogWriter {
+	w := &ConsoleLogWriter{
+		rec:    make(chan *LogRecord, LogBufferLength),
+		errout: errw,
+		split:  true,
+	}
+	go w.run(out)
+	return w
 }
 
-func (w ConsoleLogWriter) run(out io.Writer) {
+// isTerminal reports whether out is attached to a terminal
+func isTerminal(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (w *ConsoleLogWriter) run(out io.Writer) {
 	var timestr string
 	var timestrAt int64
 
-	for rec := range w {
+	tty := isTerminal(out)
+	errtty := isTerminal(w.errout)
+	noColor := os.Getenv("NO_COLOR") != ""
+
+	for rec := range w.rec {
 		if at := rec.Created.UnixNano() / 1e9; at != timestrAt {
-			timestr, timestrAt = rec.Created.Format("15:04:05 MST 2006/01/02"), at
+			timestr, timestrAt = recordIn(rec, w.loc).Created.Format("15:04:05 MST 2006/01/02"), at
 		}
-		fmt.Fprint(out, "[", timestr, "] [", levelStrings[rec.Level], "] ", rec.Message, "\n")
+		dest, desttty := out, tty
+		if w.split && w.errout != nil && rec.Level >= WARNING {
+			dest, desttty = w.errout, errtty
+		}
+		for _, band := range w.bands {
+			if rec.Level >= band.min && rec.Level <= band.max {
+				dest, desttty = band.out, band.tty
+				break
+			}
+		}
+		if len(w.format) > 0 {
+			fmt.Fprint(dest, withLineSeparator(FormatLogRecord(w.format, recordIn(rec, w.loc)), w.lineSep))
+			rec.release()
+			continue
+		}
+		lvl := levelStrings[rec.Level]
+		if w.colors && !noColor && (desttty || w.forceColor) {
+			lvl = levelColors[rec.Level] + lvl + colorReset
+		}
+		msg := rec.Message
+		if len(rec.Fields) > 0 {
+			buf := bytes.NewBufferString(msg)
+			writeFields(buf, rec.Fields)
+			msg = buf.String()
+		}
+		sep := "\n"
+		if len(w.lineSep) > 0 {
+			sep = w.lineSep
+		}
+		fmt.Fprint(dest, "[", timestr, "] [", lvl, "] ", msg, sep)
+		rec.release()
 	}
 }
 
 // This is the ConsoleLogWriter's output method.  This will block if the output
 // buffer is full.
-func (w ConsoleLogWriter) LogWrite(rec *LogRecord) {
-	w <- rec
+func (w *ConsoleLogWriter) LogWrite(rec *LogRecord) {
+	w.rec <- rec
 }
 
 // Close stops the logger from sending messages to standard output.  Attempts to
 // send log messages to this logger after a Close have undefined behavior.
-func (w ConsoleLogWriter) Close() {
-	close(w)
-	time.Sleep(50 * time.Millisecond) // Try to give console I/O time to complete
+// Calling Close again does nothing.
+func (w *ConsoleLogWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.rec)
+		time.Sleep(50 * time.Millisecond) // Try to give console I/O time to complete
+	})
+}
+
+// SetColors changes whether the level is colorized (chainable).  Colors are
+// only written when the output is a terminal, unless forced with
+// SetForceColor, and never when the NO_COLOR environment variable is set.
+// Must be called before the first log message is written.
+func (w *ConsoleLogWriter) SetColors(colors bool) *ConsoleLogWriter {
+	w.colors = colors
+	return w
+}
+
+// SetForceColor makes SetColors take effect even when the output is not a
+// terminal (chainable).  Must be called before the first log message is
+// written.
+func (w *ConsoleLogWriter) SetForceColor(force bool) *ConsoleLogWriter {
+	w.forceColor = force
+	return w
+}
+
+// SetSplitStreams changes whether records at WARNING and above are written to
+// standard error instead of standard output (chainable).  Must be called
+// before the first log message is written.
+func (w *ConsoleLogWriter) SetSplitStreams(split bool) *ConsoleLogWriter {
+	w.split = split
+	return w
+}
+
+// SetFormat changes the format of each record (chainable), using the same
+// directives as FileLogWriter.SetFormat.  An empty format restores the default
+// console layout; colors are only written with the default layout.  Must be
+// called before the first log message is written.
+func (w *ConsoleLogWriter) SetFormat(format string) *ConsoleLogWriter {
+	w.format = format
+	noteFormat(format)
+	return w
+}
+
+// SetUTC makes the writer render times in UTC, or in local time if utc is
+// false, whichever its logger's Logger.SetUTC gives the records (chainable).
+// Must be called before the first log message is written.
+func (w *ConsoleLogWriter) SetUTC(utc bool) *ConsoleLogWriter {
+	w.loc = utcLocation(utc)
+	return w
+}
+
+// SetLineSeparator changes what terminates each record in place of "\n"
+// (chainable), e.g. "\r\n".  Must be called before the first log message is
+// written.
+func (w *ConsoleLogWriter) SetLineSeparator(sep string) *ConsoleLogWriter {
+	w.lineSep = sep
+	return w
+}
+
+// SetLevelWriter sends the records with levels from minLvl to maxLvl inclusive
+// to out (chainable), e.g. to route audit events to a stream of their own.
+// Bands are checked in the order they were set, and the first containing a
+// record's level wins; records in no band go to the writer's usual stream, as
+// chosen by SetSplitStreams.  A nil out is ignored.  Must be called before the
+// first log message is written.
+func (w *ConsoleLogWriter) SetLevelWriter(minLvl, maxLvl Level, out io.Writer) *ConsoleLogWriter {
+	if out == nil {
+		return w
+	}
+	w.bands = append(w.bands, levelWriter{min: minLvl, max: maxLvl, out: out, tty: isTerminal(out)})
+	return w
 }
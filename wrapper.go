@@ -3,14 +3,20 @@
 package log4go
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 )
 
 var (
+	// Global is the default logger used by the package-level functions, such
+	// as Info and Error, much like the standard log package's default logger.
+	// It logs DEBUG and above to standard output until it is configured, e.g.
+	// with LoadGlobalConfiguration or AddFilter.
 	Global Logger
 )
 
@@ -23,6 +29,24 @@ func LoadConfiguration(filename string) error {
 	return Global.LoadConfiguration(filename)
 }
 
+// LoadGlobalConfiguration configures the Global logger from a file, for
+// programs which use only the package-level functions.  Unlike
+// LoadConfiguration, which reads XML only, the format is chosen from the file
+// extension (.json, .yaml or .yml, otherwise XML).
+func LoadGlobalConfiguration(filename string) error {
+	return Global.loadConfigurationFile(filename)
+}
+
+// Wrapper for (*Logger).LoadConfigurationFromReaderWarnings
+func LoadConfigurationFromReaderWarnings(r io.Reader, filename string) (warnings []string, err error) {
+	return Global.LoadConfigurationFromReaderWarnings(r, filename)
+}
+
+// Wrapper for (*Logger).LoadConfigurationFS
+func LoadConfigurationFS(fsys fs.FS, name string) error {
+	return Global.LoadConfigurationFS(fsys, name)
+}
+
 // Wrapper for (*Logger).LoadConfigurationFromReader
 func LoadConfigurationFromReader(r io.Reader, filename string) error {
 	return Global.LoadConfigurationFromReader(r, filename)
@@ -33,11 +57,21 @@ func AddFilter(name string, lvl Level, writer LogWriter) {
 	Global.AddFilter(name, lvl, writer)
 }
 
+// Wrapper for (*Logger).RemoveFilter
+func RemoveFilter(name string) {
+	Global.RemoveFilter(name)
+}
+
 // Wrapper for (*Logger).Close (closes and removes all logwriters)
 func Close() {
 	Global.Close()
 }
 
+// Wrapper for (*Logger).CloseOnSignal
+func CloseOnSignal(sigs ...os.Signal) (stop func()) {
+	return Global.CloseOnSignal(sigs...)
+}
+
 func Crash(args ...interface{}) {
 	if len(args) > 0 {
 		Global.intLogf(CRITICAL, strings.Repeat(" %v", len(args))[1:], args...)
@@ -104,70 +138,30 @@ func Logf(lvl Level, format string, args ...interface{}) {
 	Global.intLogf(lvl, format, args...)
 }
 
-// Send a closure log message
-// Wrapper for (*Logger).Logc
-func Logc(lvl Level, closure func() string) {
-	Global.intLogc(lvl, closure)
+// Send a formatted log message with key/value fields
+// Wrapper for (*Logger).LogWithFields
+func LogWithFields(lvl Level, fields map[string]interface{}, format string, args ...interface{}) {
+	Global.intLogFields(lvl, fields, format, args...)
 }
 
-// Utility for finest log messages (see Debug() for parameter explanation)
-// Wrapper for (*Logger).Finest
-func Finest(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINEST
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		Global.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		Global.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		Global.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
-	}
+// Wrapper for (*Logger).LogError
+func LogError(lvl Level, err error, msg string) {
+	Global.intLogFields(lvl, errorFields(err), "%s", msg)
 }
 
-// Utility for fine log messages (see Debug() for parameter explanation)
-// Wrapper for (*Logger).Fine
-func Fine(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINE
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		Global.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		Global.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		Global.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+// Send a formatted log message with fields from a context
+// Wrapper for (*Logger).LogCtx
+func LogCtx(ctx context.Context, lvl Level, format string, args ...interface{}) {
+	if Global.skip(lvl) {
+		return
 	}
+	Global.intLogFields(lvl, contextValues(ctx), format, args...)
 }
 
-// Utility for debug log messages
-// When given a string as the first argument, this behaves like Logf but with the DEBUG log level (e.g. the first argument is interpreted as a format for the latter arguments)
-// When given a closure of type func()string, this logs the string returned by the closure iff it will be logged.  The closure runs at most one time.
-// When given anything else, the log message will be each of the arguments formatted with %v and separated by spaces (ala Sprint).
-// Wrapper for (*Logger).Debug
-func Debug(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = DEBUG
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		Global.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		Global.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		Global.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
-	}
+// Send a closure log message
+// Wrapper for (*Logger).Logc
+func Logc(lvl Level, closure func() string) {
+	Global.intLogc(lvl, closure)
 }
 
 // Utility for trace log messages (see Debug() for parameter explanation)
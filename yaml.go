@@ -0,0 +1,58 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build log4go_yaml
+
+package log4go
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+type yamlFilter struct {
+	Enabled    interface{}            `yaml:"enabled"`
+	Tag        string                 `yaml:"tag"`
+	Level      string                 `yaml:"level"`
+	Type       string                 `yaml:"type"`
+	Properties map[string]interface{} `yaml:"properties"`
+	Filters    []yamlFilter           `yaml:"filters"`
+}
+
+type yamlLoggerConfig struct {
+	Format  string       `yaml:"format"`
+	Filters []yamlFilter `yaml:"filters"`
+}
+
+func (yf yamlFilter) filterConfig() filterConfig {
+	props := make(map[string]string, len(yf.Properties))
+	for name, value := range yf.Properties {
+		props[name] = trimProp(configValueString(value))
+	}
+	children := make([]filterConfig, 0, len(yf.Filters))
+	for _, child := range yf.Filters {
+		children = append(children, child.filterConfig())
+	}
+	return filterConfig{
+		Enabled:    configValueString(yf.Enabled),
+		Tag:        yf.Tag,
+		Level:      yf.Level,
+		Type:       yf.Type,
+		Properties: props,
+		Children:   children,
+	}
+}
+
+func parseYAMLConfiguration(contents []byte, filename string) ([]filterConfig, error) {
+	yc := new(yamlLoggerConfig)
+	if err := yaml.Unmarshal(contents, yc); err != nil {
+		return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse YAML configuration in %q: %s\n", filename, err)
+	}
+
+	filters := make([]filterConfig, 0, len(yc.Filters))
+	for _, yamlfilt := range yc.Filters {
+		filters = append(filters, yamlfilt.filterConfig())
+	}
+	applyDefaultFormat(filters, trimProp(yc.Format))
+	return filters, nil
+}
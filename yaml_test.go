@@ -0,0 +1,145 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build log4go_yaml
+
+package log4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestYAMLConfig(t *testing.T) {
+	const (
+		xmlfile  = "_yamlconfig.xml"
+		yamlfile = "_yamlconfig.yaml"
+	)
+
+	xmlconf := `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>WARNING</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>FINE</level>
+    <property name="filename">_yamlconfig.log</property>
+    <property name="maxlines">1K</property>
+  </filter>
+  <filter enabled="true">
+    <tag>xmllog</tag>
+    <type>xml</type>
+    <level>ERROR</level>
+    <property name="filename">_yamlconfig.xmllog</property>
+  </filter>
+  <filter enabled="false">
+    <tag>donotopen</tag>
+    <type>socket</type>
+    <level>FINEST</level>
+    <property name="endpoint">192.168.1.255:12124</property>
+  </filter>
+</logging>`
+
+	yamlconf := `filters:
+  - enabled: true
+    tag: stdout
+    type: console
+    level: WARNING
+  - enabled: true
+    tag: file
+    type: file
+    level: FINE
+    properties:
+      filename: _yamlconfig.log
+      maxlines: 1K
+  - enabled: "true"
+    tag: xmllog
+    type: xml
+    level: ERROR
+    properties:
+      filename: _yamlconfig.xmllog
+  - enabled: false
+    tag: donotopen
+    type: socket
+    level: FINEST
+    properties:
+      endpoint: 192.168.1.255:12124
+`
+
+	if err := ioutil.WriteFile(xmlfile, []byte(xmlconf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", xmlfile, err)
+	}
+	defer os.Remove(xmlfile)
+	if err := ioutil.WriteFile(yamlfile, []byte(yamlconf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", yamlfile, err)
+	}
+	defer os.Remove(yamlfile)
+	defer os.Remove("_yamlconfig.log")
+	defer os.Remove("_yamlconfig.xmllog")
+
+	xlog := make(Logger)
+	if err := xlog.LoadConfiguration(xmlfile); err != nil {
+		t.Fatalf("YAMLConfig: XML load failed: %s", err)
+	}
+	defer xlog.Close()
+
+	ylog := make(Logger)
+	if err := ylog.LoadConfigurationYAML(yamlfile); err != nil {
+		t.Fatalf("YAMLConfig: YAML load failed: %s", err)
+	}
+	defer ylog.Close()
+
+	if len(ylog) != len(xlog) {
+		t.Fatalf("YAMLConfig: Expected %d filters, found %d", len(xlog), len(ylog))
+	}
+	for tag, xfilt := range xlog {
+		yfilt, ok := ylog[tag]
+		if !ok {
+			t.Errorf("YAMLConfig: Expected %s logger", tag)
+			continue
+		}
+		if yfilt.Level != xfilt.Level {
+			t.Errorf("YAMLConfig: Expected %s to be set to level %d, found %d", tag, xfilt.Level, yfilt.Level)
+		}
+		if yt, xt := fmt.Sprintf("%T", yfilt.LogWriter), fmt.Sprintf("%T", xfilt.LogWriter); yt != xt {
+			t.Errorf("YAMLConfig: Expected %s to be %s, found %s", tag, xt, yt)
+		}
+	}
+
+	// Levels are case-sensitive, just like in XML
+	bad := "filters:\n  - enabled: true\n    tag: stdout\n    type: console\n    level: debug\n"
+	if err := make(Logger).LoadConfigurationFromReaderYAML(strings.NewReader(bad), "bad.yaml"); err == nil {
+		t.Errorf("YAMLConfig: Expected lowercase level to be rejected")
+	}
+}
+
+func TestLoadGlobalConfigurationYAML(t *testing.T) {
+	const yamlfile = "_globalconfig.yml"
+	yamlconf := `filters:
+  - enabled: true
+    tag: discard
+    type: "null"
+    level: WARNING
+`
+	if err := ioutil.WriteFile(yamlfile, []byte(yamlconf), 0644); err != nil {
+		t.Fatalf("Could not write %s: %s", yamlfile, err)
+	}
+	defer os.Remove(yamlfile)
+
+	saved := Global
+	Global = make(Logger)
+	defer func() { Global = saved }()
+
+	if err := LoadGlobalConfiguration(yamlfile); err != nil {
+		t.Fatalf("LoadGlobalConfiguration: %s", err)
+	}
+	defer Global.Close()
+	if filt, ok := Global["discard"]; !ok || filt.Level != WARNING {
+		t.Errorf("Expected the discard filter at WARNING, found %v", Global)
+	}
+}
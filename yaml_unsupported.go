@@ -0,0 +1,13 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !log4go_yaml
+
+package log4go
+
+import (
+	"fmt"
+)
+
+func parseYAMLConfiguration(contents []byte, filename string) ([]filterConfig, error) {
+	return nil, fmt.Errorf("LoadConfiguration: Error: Could not parse YAML configuration in %q: YAML support requires building with the log4go_yaml tag\n", filename)
+}
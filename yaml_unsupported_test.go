@@ -0,0 +1,17 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !log4go_yaml
+
+package log4go
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAMLConfigUnsupported(t *testing.T) {
+	err := make(Logger).LoadConfigurationFromReaderYAML(strings.NewReader("filters: []\n"), "config.yaml")
+	if err == nil || !strings.Contains(err.Error(), "log4go_yaml") {
+		t.Errorf("Expected an error naming the log4go_yaml tag, found %v", err)
+	}
+}